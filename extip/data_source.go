@@ -4,12 +4,15 @@ package extip
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net"
 	"net/http"
-	"sync"
+	"net/url"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
@@ -17,41 +20,151 @@ import (
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
-// HTTP client cache with timeout-based keys.
-var (
-	httpClients = make(map[time.Duration]*http.Client)
-	clientMutex sync.RWMutex
+const (
+	// defaultResolver is queried when neither the data source nor the
+	// provider chooses a resolver.
+	defaultResolver = "https://checkip.amazonaws.com/"
+
+	// defaultClientTimeout is the request timeout in milliseconds.
+	defaultClientTimeout = 1000
+
+	// defaultMaxRedirects matches the net/http default redirect limit.
+	defaultMaxRedirects = 10
+
+	// defaultMaxResponseBytes bounds how much of a resolver response is read.
+	// Echo services answer with a handful of bytes, so 64 KiB is generous.
+	defaultMaxResponseBytes = 64 * 1024
+
+	// maxDiagnosticEcho is the longest resolver response echoed back in errors.
+	maxDiagnosticEcho = 64
 )
 
-// getHTTPClient returns an HTTP client with the specified timeout, reusing existing clients.
-func getHTTPClient(timeout time.Duration) *http.Client {
-	clientMutex.RLock()
-	if client, exists := httpClients[timeout]; exists {
-		clientMutex.RUnlock()
-		return client
-	}
-	clientMutex.RUnlock()
+// Values of the computed source attribute, recording where ipaddress came from.
+const (
+	sourceResolver = "resolver"
+	sourceFallback = "fallback"
+	sourceOverride = "override"
+)
+
+// lookupOptions holds the per-request settings used when querying a resolver.
+type lookupOptions struct {
+	// clientTimeout is the request timeout in milliseconds, 0 means no timeout.
+	clientTimeout int
+	// maxResponseBytes caps the response body size, 0 means the default.
+	maxResponseBytes int
+	// expectedContentTypes lists accepted media types, empty accepts any.
+	expectedContentTypes []string
+	// acceptedStatusCodes lists successful status codes, empty means 200 only.
+	acceptedStatusCodes []int
+	// maxRedirects is the number of redirects followed, 0 disables them.
+	maxRedirects int
+	// allowCrossHostRedirects permits redirects to a host other than the resolver's.
+	allowCrossHostRedirects bool
+	// dialTimeout bounds DNS lookup and TCP connect in milliseconds, 0 means no limit.
+	dialTimeout int
+	// tlsHandshakeTimeout bounds the TLS handshake in milliseconds, 0 means no limit.
+	tlsHandshakeTimeout int
+	// responseHeaderTimeout bounds the wait for response headers in milliseconds, 0 means no limit.
+	responseHeaderTimeout int
+	// totalTimeout bounds the whole lookup including the body in milliseconds, 0 means no limit.
+	totalTimeout int
+	// transports supplies connections, nil uses the fallback pool.
+	transports *transportPool
+	// network restricts connections to "tcp4" or "tcp6", empty allows both.
+	network string
+	// parse extracts the address from the response body, nil trims plain text.
+	parse responseParser
+	// policy restricts the endpoints that may be contacted, nil allows all.
+	policy *resolverPolicy
+	// proxy is the URL of the proxy HTTP lookups go through, empty connects directly.
+	proxy string
+	// mocks answers lookups instead of the network, nil makes real requests.
+	mocks *mockResponses
+	// recorder records resolver traffic, nil records nothing.
+	recorder *trafficRecorder
+	// sourceAddress is the local address connections are bound to, empty lets the system choose.
+	sourceAddress string
+	// sourceInterface names the interface whose address connections are bound to.
+	sourceInterface string
+	// upnpDeviceURL is the description URL of the UPnP gateway, empty discovers it with SSDP.
+	upnpDeviceURL string
+	// upnpDiscoveryTimeout bounds SSDP discovery in milliseconds, 0 means the default.
+	upnpDiscoveryTimeout int
+	// metadataEndpoint replaces the base URL of metadata resolvers, empty uses their own.
+	metadataEndpoint string
+	// payload is sent to tcp:// and udp:// resolvers before their answer is read.
+	payload string
+	// tunnel carries connections over SSH from a remote host, nil connects directly.
+	tunnel *sshTunnel
+}
 
-	clientMutex.Lock()
-	defer clientMutex.Unlock()
+// lookupResult is a resolver answer along with how it was obtained.
+type lookupResult struct {
+	ip           string
+	httpStatus   int
+	resolverUsed string
+	remoteAddr   string
+	localAddr    string
+	tlsVersion   string
+	latency      time.Duration
+	attempts     int
+}
 
-	// Double-check after acquiring write lock
-	if client, exists := httpClients[timeout]; exists {
-		return client
+// transportConfig returns the connection configuration for these options.
+func (o lookupOptions) transportConfig() transportConfig {
+	return transportConfig{
+		network:               o.network,
+		dialTimeout:           msToDuration(o.dialTimeout),
+		tlsHandshakeTimeout:   msToDuration(o.tlsHandshakeTimeout),
+		responseHeaderTimeout: msToDuration(o.responseHeaderTimeout),
+		proxy:                 o.proxy,
+		sourceAddress:         o.sourceAddress,
 	}
+}
 
-	client := &http.Client{
-		Timeout: timeout,
-		Transport: &http.Transport{
-			MaxIdleConns:        100,
-			MaxIdleConnsPerHost: 10,
-			IdleConnTimeout:     90 * time.Second,
-		},
+// timeoutConfigured reports whether the timeout argument setting is non-zero.
+func (o lookupOptions) timeoutConfigured(setting string) bool {
+	switch setting {
+	case "dial_timeout":
+		return o.dialTimeout > 0
+	case "tls_handshake_timeout":
+		return o.tlsHandshakeTimeout > 0
+	case "response_header_timeout":
+		return o.responseHeaderTimeout > 0
+	case "total_timeout":
+		return o.totalTimeout > 0
+	default:
+		return false
+	}
+}
+
+// httpClient returns the client HTTP lookups with these options are sent with.
+func (o lookupOptions) httpClient() *http.Client {
+	transports := o.transports
+	if transports == nil {
+		transports = fallbackTransports
 	}
-	httpClients[timeout] = client
+	client := transports.getHTTPClient(o)
+	client.Transport = o.roundTripper(client.Transport)
 	return client
 }
 
+// roundTripper returns base, replaced by the mock responses and wrapped by the
+// traffic recorder when they are configured.
+func (o lookupOptions) roundTripper(base http.RoundTripper) http.RoundTripper {
+	if o.mocks != nil {
+		base = o.mocks
+	}
+	if o.recorder != nil {
+		base = o.recorder.wrap(base)
+	}
+	return base
+}
+
+func msToDuration(ms int) time.Duration {
+	return time.Duration(ms) * time.Millisecond
+}
+
 func dataSource() *schema.Resource {
 	return &schema.Resource{
 		ReadContext: dataSourceReadContext,
@@ -64,103 +177,544 @@ func dataSource() *schema.Resource {
 					Type: schema.TypeString,
 				},
 			},
+			"latency_ms": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The time taken by the resolver lookup in ms",
+			},
+			"http_status": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The HTTP status code returned by the resolver",
+			},
+			"resolver_used": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The URL that answered the lookup, after following redirects, with credentials redacted",
+			},
+			"remote_addr": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The IP address of the resolver the provider connected to",
+			},
+			"local_source_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The local address the connection to the resolver was made from. With a proxy, the address of the connection to the proxy",
+			},
+			"behind_nat": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether the external address differs from local_source_address, meaning the traffic is translated on its way out. Not set when the local address is unknown",
+			},
+			"tls_version": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The TLS version negotiated with the resolver, empty for plain HTTP",
+			},
+			"attempts": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The number of HTTP requests made to get the answer, including redirects",
+			},
 			"resolver": {
 				Type:        schema.TypeString,
 				Optional:    true,
-				Default:     "https://checkip.amazonaws.com/",
-				Description: "The URL to use to resolve the external IP address\nIf not set, defaults to https://checkip.amazonaws.com/",
+				Default:     defaultResolver,
+				Description: "The URL or built-in resolver name (such as aws, ipify, opendns-dns or aws-imds) to use to resolve the external IP address\nIf not set, the provider resolver and resolvers are used, falling back to https://checkip.amazonaws.com/. The extip_resolvers data source lists the built-in names\nA natpmp://, pcp:// or upnp:// URL asks the local gateway instead, such as natpmp:// for the default gateway or pcp://192.168.1.1\nA tcp://HOST:PORT or udp://HOST:PORT URL reads the first line a plain socket service answers with",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+				ValidateFunc: validateResolver,
+			},
+			"max_response_bytes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultMaxResponseBytes,
+				Description:  "The maximum number of bytes to read from the resolver response\nIf not set, defaults to 65536 (64 KiB). Larger responses are rejected",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"expected_content_types": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Media types the resolver response must have, such as text/plain. A type/* entry matches any subtype\nIf not set, any content type is accepted",
 				Elem: &schema.Schema{
 					Type: schema.TypeString,
 				},
-				ValidateFunc: validation.IsURLWithHTTPorHTTPS,
 			},
 			"client_timeout": {
 				Type:        schema.TypeInt,
 				Optional:    true,
-				Default:     1000,
-				Description: "The time to wait for a response in ms\nIf not set, defaults to 1000 (1 second). Setting to 0 means infinite (no timeout)",
+				Default:     defaultClientTimeout,
+				Description: "The time to wait for a response in ms\nIf not set, the provider client_timeout is used, falling back to 1000 (1 second). Setting to 0 means infinite (no timeout)",
 				Elem: &schema.Schema{
 					Type: schema.TypeInt,
 				},
 			},
+			"accepted_status_codes": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "HTTP status codes treated as a successful resolver response\nIf not set, only 200 is accepted",
+				Elem: &schema.Schema{
+					Type:         schema.TypeInt,
+					ValidateFunc: validation.IntBetween(100, 599),
+				},
+			},
+			"max_redirects": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultMaxRedirects,
+				Description:  "The maximum number of redirects to follow\nIf not set, defaults to 10. Setting to 0 disables redirects",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"allow_cross_host_redirects": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Follow redirects that point to a different host than the resolver\nIf not set, defaults to true",
+			},
+			"dial_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The time to wait for DNS resolution and the TCP connection in ms\nIf not set, only client_timeout applies",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"tls_handshake_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The time to wait for the TLS handshake in ms\nIf not set, only client_timeout applies",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"response_header_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The time to wait for response headers once the request is sent in ms\nIf not set, only client_timeout applies",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"total_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The time allowed for the whole lookup, including redirects and reading the body, in ms\nIf not set, only client_timeout applies",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
 			"validate_ip": {
 				Type:        schema.TypeBool,
 				Optional:    true,
-				Description: "Validate if the returned response is a valid ip address",
+				Description: "Validate if the returned response is a valid ip address\nIf not set, the provider validate_ip is used",
 				Elem: &schema.Schema{
 					Type: schema.TypeBool,
 				},
 			},
+			"source_address": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "The local address to send the lookup from, on hosts with several uplinks\nIf not set, the system chooses based on the route to the resolver",
+				ValidateFunc:  validation.IsIPAddress,
+				ConflictsWith: []string{"source_interface", "ssh"},
+			},
+			"source_interface": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "The local interface to send the lookup from. Its first global unicast address of the family the resolver needs is used; loopback and link-local addresses are skipped",
+				ConflictsWith: []string{"source_address", "ssh"},
+			},
+			"ssh": {
+				Type:          schema.TypeList,
+				Optional:      true,
+				MaxItems:      1,
+				Description:   "Look up the external address of a remote host, such as a bastion, by connecting to it over SSH",
+				Elem:          sshSchema(),
+				ConflictsWith: []string{"source_address", "source_interface"},
+			},
+			"metadata_endpoint": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The base URL of the instance metadata service read by the aws-imds, gcp-metadata and azure-imds resolvers, such as http://[fd00:ec2::254] for the AWS IPv6 endpoint\nIf not set, the standard endpoint of the resolver is used",
+				ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+			},
+			"upnp_device_url": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The URL of the gateway's UPnP device description, such as http://192.168.1.1:5000/rootDesc.xml, used by the upnp:// resolver instead of SSDP discovery",
+				ValidateFunc: validation.IsURLWithHTTPorHTTPS,
+			},
+			"upnp_discovery_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultUPnPDiscoveryTimeout,
+				Description:  "The time to wait for a gateway to answer SSDP discovery with the upnp:// resolver in ms\nIf not set, defaults to 2000 (2 seconds)",
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+			"socket_payload": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Data sent to a tcp:// or udp:// resolver once connected, such as \"\\n\" for services that wait for a line before answering\nIf not set, a tcp:// resolver is only read from and a udp:// resolver is sent an empty datagram",
+			},
+			"fail_on_error": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Fail the plan when the lookup fails\nIf set to false, a warning is shown and ipaddress is set to fallback_ipaddress instead",
+			},
+			"fallback_ipaddress": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The address to return when the lookup fails and fail_on_error is false\nIf not set, ipaddress is an empty string",
+				ValidateFunc: validation.IsIPAddress,
+			},
+			"succeeded": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether the lookup succeeded. False when the fallback address was used",
+			},
+			"source": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "Where ipaddress came from: resolver for a lookup, fallback when fallback_ipaddress was used or override when the provider override_ipaddress was used",
+			},
 		},
 	}
 }
 
-func getExternalIPFrom(service string, clientTimeout int) (string, error) {
-	timeout := time.Duration(clientTimeout) * time.Millisecond
-	client := getHTTPClient(timeout)
+// getExternalIPFrom queries service for the external IP. The returned result
+// is never nil, on failure it holds whatever metadata was collected.
+func getExternalIPFrom(ctx context.Context, service string, opts lookupOptions) (result *lookupResult, err error) {
+	result = &lookupResult{resolverUsed: redactURL(service)}
+	start := time.Now()
+	trace := newRequestTrace()
+
+	logDebug(ctx, "Querying resolver", map[string]interface{}{
+		logFieldResolver: result.resolverUsed,
+		logFieldStrategy: "http",
+		logFieldAttempt:  1,
+	})
+
+	defer func() {
+		result.latency = time.Since(start)
+		trace.fill(result)
+		logLookupOutcome(ctx, result, err)
+	}()
 
-	// Create request with context to satisfy noctx linter
-	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, service, http.NoBody)
+	opts, err = opts.bindSource()
 	if err != nil {
-		return "", fmt.Errorf("failed to create request: %w", err)
+		return result, err
+	}
+
+	client := opts.httpClient()
+
+	if opts.totalTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msToDuration(opts.totalTimeout))
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(trace.withContext(ctx), http.MethodGet, service, http.NoBody)
+	if err != nil {
+		return result, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	rsp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return result, classifyTransportError(result.resolverUsed, trace.annotateTimeout(ctx, err, opts))
 	}
 
 	defer func() {
 		if closeErr := rsp.Body.Close(); closeErr != nil {
 			// Log the error but don't fail the operation
-			_ = closeErr
+			logWarn(ctx, "Failed to close resolver response body", map[string]interface{}{
+				logFieldResolver: result.resolverUsed,
+				logFieldError:    closeErr.Error(),
+			})
 		}
 	}()
 
-	if rsp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("HTTP request error. Response code: %d", rsp.StatusCode)
+	result.httpStatus = rsp.StatusCode
+	result.resolverUsed = redactURL(rsp.Request.URL.String())
+	if rsp.TLS != nil {
+		result.tlsVersion = tls.VersionName(rsp.TLS.Version)
+	}
+
+	if !statusAccepted(rsp.StatusCode, opts.acceptedStatusCodes) {
+		return result, &StatusError{Resolver: result.resolverUsed, StatusCode: rsp.StatusCode}
 	}
 
-	buf, err := io.ReadAll(rsp.Body)
+	if err := checkContentType(rsp.Header.Get("Content-Type"), opts.expectedContentTypes); err != nil {
+		return result, classifyTransportError(result.resolverUsed, err)
+	}
+
+	trace.setPhase(phaseReadingBody)
+	buf, err := readLimited(rsp.Body, opts.maxResponseBytes)
 	if err != nil {
-		return "", err
+		return result, classifyTransportError(result.resolverUsed, trace.annotateTimeout(ctx, err, opts))
+	}
+
+	if opts.parse != nil {
+		ip, err := opts.parse(buf)
+		if err != nil {
+			return result, &ResponseError{Resolver: result.resolverUsed, Setting: "resolver", Reason: err.Error()}
+		}
+		result.ip = strings.TrimSpace(ip)
+		return result, nil
 	}
 
 	// Optimize string conversion by avoiding unnecessary allocations
-	trimmed := bytes.TrimSpace(buf)
-	return string(trimmed), nil
+	result.ip = string(bytes.TrimSpace(buf))
+	return result, nil
+}
+
+// logLookupOutcome logs the result of a resolver lookup.
+func logLookupOutcome(ctx context.Context, result *lookupResult, err error) {
+	fields := map[string]interface{}{
+		logFieldResolver:   result.resolverUsed,
+		logFieldStatus:     result.httpStatus,
+		logFieldLatency:    result.latency.Milliseconds(),
+		logFieldRemoteAddr: result.remoteAddr,
+		logFieldTLSVersion: result.tlsVersion,
+		logFieldAttempt:    result.attempts,
+	}
+
+	if err != nil {
+		fields[logFieldError] = err.Error()
+		logWarn(ctx, "Resolver lookup failed", fields)
+		return
+	}
+
+	fields[logFieldIPAddress] = result.ip
+	logDebug(ctx, "Resolver lookup succeeded", fields)
 }
 
-func dataSourceReadContext(_ context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
-	err := dataSourceRead(d, meta)
+// redactURL hides any credentials embedded in a resolver URL.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
 	if err != nil {
-		return diag.FromErr(err)
+		return raw
 	}
-	return nil
+	return u.Redacted()
 }
 
-func dataSourceRead(d *schema.ResourceData, _ interface{}) error {
-	resolver, ok := d.Get("resolver").(string)
-	if !ok {
-		return errors.New("resolver is not a string")
+// redirectPolicy returns a CheckRedirect function enforcing the redirect
+// settings in opts.
+func redirectPolicy(opts lookupOptions) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > opts.maxRedirects {
+			return &RedirectError{
+				Setting: "max_redirects",
+				Reason:  fmt.Sprintf("stopped after %d redirects (max_redirects)", opts.maxRedirects),
+			}
+		}
+
+		logDebug(req.Context(), "Following resolver redirect", map[string]interface{}{
+			logFieldResolver: redactURL(req.URL.String()),
+			logFieldAttempt:  len(via) + 1,
+		})
+
+		if err := opts.policy.checkURL(req.URL.String()); err != nil {
+			return err
+		}
+
+		origin := via[0].URL.Hostname()
+		if !opts.allowCrossHostRedirects && !strings.EqualFold(origin, req.URL.Hostname()) {
+			return &RedirectError{
+				Setting: "allow_cross_host_redirects",
+				Reason: fmt.Sprintf(
+					"redirect from host %q to %q not allowed (allow_cross_host_redirects is false)",
+					origin, req.URL.Hostname(),
+				),
+			}
+		}
+
+		return nil
+	}
+}
+
+// statusAccepted reports whether code is one of the accepted status codes.
+func statusAccepted(code int, accepted []int) bool {
+	if len(accepted) == 0 {
+		return code == http.StatusOK
+	}
+
+	for _, c := range accepted {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// readLimited reads the whole body, failing once it grows past maxBytes.
+func readLimited(body io.Reader, maxBytes int) ([]byte, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxResponseBytes
+	}
+
+	buf, err := io.ReadAll(io.LimitReader(body, int64(maxBytes)+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(buf) > maxBytes {
+		return nil, &ResponseError{
+			Setting: "max_response_bytes",
+			Reason:  fmt.Sprintf("response body exceeds max_response_bytes (%d bytes)", maxBytes),
+		}
 	}
 
-	clientTimeout, ok := d.Get("client_timeout").(int)
+	return buf, nil
+}
+
+// checkContentType verifies the response media type is one of the expected ones.
+func checkContentType(header string, expected []string) error {
+	if len(expected) == 0 {
+		return nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return contentTypeError(header, expected)
+	}
+
+	for _, want := range expected {
+		want = strings.ToLower(strings.TrimSpace(want))
+		if want == mediaType {
+			return nil
+		}
+		if prefix, ok := strings.CutSuffix(want, "/*"); ok && strings.HasPrefix(mediaType, prefix+"/") {
+			return nil
+		}
+	}
+
+	return contentTypeError(mediaType, expected)
+}
+
+func contentTypeError(got string, expected []string) error {
+	return &ResponseError{
+		Setting: "expected_content_types",
+		Reason: fmt.Sprintf("unexpected response content type %q, expected one of: %s",
+			truncateForDiagnostic(got), strings.Join(expected, ", ")),
+	}
+}
+
+// truncateForDiagnostic shortens resolver output so that errors stay readable.
+func truncateForDiagnostic(s string) string {
+	runes := []rune(s)
+	if len(runes) <= maxDiagnosticEcho {
+		return s
+	}
+	return fmt.Sprintf("%s... (%d more characters truncated)",
+		string(runes[:maxDiagnosticEcho]), len(runes)-maxDiagnosticEcho)
+}
+
+func dataSourceReadContext(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	if override := providerConfigFrom(meta).overrideIPAddress; override != "" {
+		return readOverride(d, override)
+	}
+
+	err := dataSourceRead(ctx, d, meta)
+	if err == nil {
+		return nil
+	}
+
+	diagnostic := errorDiagnostic(err, func(key string) bool {
+		_, ok := d.GetOk(key)
+		return ok
+	})
+
+	if failOnError, ok := d.Get("fail_on_error").(bool); ok && !failOnError {
+		return softFail(d, diagnostic)
+	}
+	return diag.Diagnostics{diagnostic}
+}
+
+// softFail records the fallback address after a failed lookup and downgrades
+// the failure to a warning, so the plan can carry on.
+func softFail(d *schema.ResourceData, failure diag.Diagnostic) diag.Diagnostics {
+	fallback, ok := d.Get("fallback_ipaddress").(string)
 	if !ok {
-		return errors.New("client_timeout is not an int")
+		return diag.Diagnostics{failure}
+	}
+
+	if err := d.Set("ipaddress", fallback); err != nil {
+		return diag.FromErr(fmt.Errorf("error setting ipaddress: %s", err.Error()))
+	}
+	if err := d.Set("succeeded", false); err != nil {
+		return diag.FromErr(fmt.Errorf("error setting succeeded: %s", err.Error()))
+	}
+	if err := d.Set("source", sourceFallback); err != nil {
+		return diag.FromErr(fmt.Errorf("error setting source: %s", err.Error()))
 	}
+	d.SetId(time.Now().UTC().Format("20060102150405"))
+
+	failure.Severity = diag.Warning
+	failure.Summary += ", using fallback address"
+	failure.Detail += fmt.Sprintf("\n\nfail_on_error is false, so ipaddress was set to %q.", fallback)
+	return diag.Diagnostics{failure}
+}
+
+// readOverride answers with the provider override address without contacting
+// any resolver, and warns so that the override is never silently in effect.
+func readOverride(d *schema.ResourceData, override string) diag.Diagnostics {
+	values := map[string]interface{}{
+		"ipaddress": override,
+		"succeeded": true,
+		"source":    sourceOverride,
+	}
+
+	for key, value := range values {
+		if err := d.Set(key, value); err != nil {
+			return diag.FromErr(fmt.Errorf("error setting %s: %s", key, err.Error()))
+		}
+	}
+	d.SetId(time.Now().UTC().Format("20060102150405"))
+
+	return diag.Diagnostics{overrideWarning("ipaddress", override)}
+}
+
+// overrideWarning tells the user attribute holds the provider override rather
+// than a looked up address.
+func overrideWarning(attribute, override string) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity: diag.Warning,
+		Summary:  "External IP lookup skipped, using override address",
+		Detail: fmt.Sprintf("The provider override_ipaddress (or the %s environment variable) is set, so %s is %q and no resolver was contacted.",
+			envOverrideIPAddress, attribute, override),
+	}
+}
 
-	ip, err := getExternalIPFrom(resolver, clientTimeout)
+func dataSourceRead(ctx context.Context, d *schema.ResourceData, meta interface{}) error {
+	ctx = withResolverLogging(ctx)
+
+	opts, err := expandLookupOptions(d)
 	if err != nil {
-		return fmt.Errorf("error requesting external IP: %s", err.Error())
+		return err
 	}
+	config := providerConfigFrom(meta)
+	opts.transports = config.transports
+	opts.policy = config.policy
+	opts.mocks = config.mocks
+	opts.recorder = config.recorder
+	config.defaults.apply(d, &opts)
+
+	resolvers, err := config.defaults.resolverCandidates(d)
+	if err != nil {
+		return err
+	}
+
+	result, err := lookupWithSSH(ctx, d, resolvers, opts)
+	if err != nil {
+		return fmt.Errorf("error requesting external IP: %w", err)
+	}
+	ip := result.ip
 
 	// Only validate IP if the flag is set
-	if v, ok := d.GetOk("validate_ip"); ok {
-		if validateIP, ok := v.(bool); ok && validateIP {
-			if net.ParseIP(ip) == nil {
-				return fmt.Errorf("validate_ip was set to true, and information from resolver was not valid IP: %s", ip)
-			}
+	if config.defaults.shouldValidateIP(d) {
+		valid := net.ParseIP(ip) != nil
+		logDebug(ctx, "Validated resolver response", map[string]interface{}{
+			logFieldResolver:  result.resolverUsed,
+			logFieldIPAddress: ip,
+			"valid":           valid,
+		})
+		if !valid {
+			return &ValidationError{Resolver: result.resolverUsed, Value: truncateForDiagnostic(ip)}
 		}
 	}
 
@@ -169,8 +723,202 @@ func dataSourceRead(d *schema.ResourceData, _ interface{}) error {
 		return fmt.Errorf("error setting ipaddress: %s", setErr.Error())
 	}
 
+	if setErr := d.Set("succeeded", true); setErr != nil {
+		return fmt.Errorf("error setting succeeded: %s", setErr.Error())
+	}
+
+	if setErr := d.Set("source", sourceResolver); setErr != nil {
+		return fmt.Errorf("error setting source: %s", setErr.Error())
+	}
+
+	if err := setLookupMetadata(d, result); err != nil {
+		return err
+	}
+
 	// Use a more efficient ID generation
 	d.SetId(time.Now().UTC().Format("20060102150405"))
 
 	return nil
 }
+
+// lookupWithSSH looks up the external address of the host in the ssh block,
+// or of this machine when the block is not set.
+func lookupWithSSH(ctx context.Context, d *schema.ResourceData, resolvers []string, opts lookupOptions) (*lookupResult, error) {
+	remote, err := expandSSHConfig(d)
+	if err != nil {
+		return nil, err
+	}
+	// Mocked lookups never reach the network, the remote host included
+	if remote == nil || opts.mocks != nil {
+		return lookupWithFallback(ctx, resolvers, opts)
+	}
+
+	tunnel, err := dialSSH(ctx, *remote)
+	if err != nil {
+		return nil, err
+	}
+	defer tunnel.close()
+	opts.tunnel = tunnel
+
+	var result *lookupResult
+	if remote.method == sshMethodConnection {
+		result, err = tunnel.connectionAddress(ctx)
+	} else {
+		result, err = lookupWithFallback(ctx, resolvers, opts)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	// Tunnelled connections have no meaningful local address
+	result.localAddr = ""
+	return result, nil
+}
+
+// lookupWithFallback queries each resolver in turn until one answers. The
+// attempts of failed resolvers are included in the result, and the last
+// error is returned when every resolver fails.
+func lookupWithFallback(ctx context.Context, resolvers []string, opts lookupOptions) (*lookupResult, error) {
+	var (
+		attempts int
+		lastErr  error
+	)
+
+	for i, resolver := range resolvers {
+		result, err := lookupExternalIP(ctx, resolver, opts)
+		if result != nil {
+			attempts += result.attempts
+		}
+		if err == nil {
+			result.attempts = attempts
+			return result, nil
+		}
+
+		lastErr = err
+		if i < len(resolvers)-1 {
+			logWarn(ctx, "Resolver failed, trying the next one", map[string]interface{}{
+				logFieldResolver: redactURL(resolver),
+				logFieldError:    err.Error(),
+			})
+		}
+	}
+
+	return nil, lastErr
+}
+
+// setLookupMetadata records how the answer was obtained in the computed attributes.
+func setLookupMetadata(d *schema.ResourceData, result *lookupResult) error {
+	values := map[string]interface{}{
+		"latency_ms":    int(result.latency.Milliseconds()),
+		"http_status":   result.httpStatus,
+		"resolver_used": result.resolverUsed,
+		"remote_addr":   result.remoteAddr,
+		"tls_version":   result.tlsVersion,
+		"attempts":      result.attempts,
+
+		"local_source_address": result.localAddr,
+	}
+
+	if behind, ok := behindNAT(result.localAddr, result.ip); ok {
+		values["behind_nat"] = behind
+	}
+
+	for key, value := range values {
+		if err := d.Set(key, value); err != nil {
+			return fmt.Errorf("error setting %s: %s", key, err.Error())
+		}
+	}
+	return nil
+}
+
+// behindNAT reports whether the external address differs from the local
+// source address. ok is false when either address is unknown.
+func behindNAT(local, external string) (behind, ok bool) {
+	localIP := net.ParseIP(local)
+	externalIP := net.ParseIP(external)
+	if localIP == nil || externalIP == nil {
+		return false, false
+	}
+	return !localIP.Equal(externalIP), true
+}
+
+// expandLookupOptions builds the request settings from the data source arguments.
+func expandLookupOptions(d *schema.ResourceData) (lookupOptions, error) {
+	contentTypes, ok := d.Get("expected_content_types").([]interface{})
+	if !ok {
+		return lookupOptions{}, errors.New("expected_content_types is not a list")
+	}
+
+	statusCodes, ok := d.Get("accepted_status_codes").([]interface{})
+	if !ok {
+		return lookupOptions{}, errors.New("accepted_status_codes is not a list")
+	}
+
+	crossHost, ok := d.Get("allow_cross_host_redirects").(bool)
+	if !ok {
+		return lookupOptions{}, errors.New("allow_cross_host_redirects is not a bool")
+	}
+
+	opts := lookupOptions{
+		expectedContentTypes:    expandStringList(contentTypes),
+		acceptedStatusCodes:     expandIntList(statusCodes),
+		allowCrossHostRedirects: crossHost,
+	}
+
+	ints := map[string]*int{
+		"client_timeout":          &opts.clientTimeout,
+		"max_response_bytes":      &opts.maxResponseBytes,
+		"max_redirects":           &opts.maxRedirects,
+		"dial_timeout":            &opts.dialTimeout,
+		"tls_handshake_timeout":   &opts.tlsHandshakeTimeout,
+		"response_header_timeout": &opts.responseHeaderTimeout,
+		"total_timeout":           &opts.totalTimeout,
+		"upnp_discovery_timeout":  &opts.upnpDiscoveryTimeout,
+	}
+	for key, field := range ints {
+		value, ok := d.Get(key).(int)
+		if !ok {
+			return lookupOptions{}, fmt.Errorf("%s is not an int", key)
+		}
+		*field = value
+	}
+
+	strs := map[string]*string{
+		"source_address":    &opts.sourceAddress,
+		"source_interface":  &opts.sourceInterface,
+		"upnp_device_url":   &opts.upnpDeviceURL,
+		"metadata_endpoint": &opts.metadataEndpoint,
+		"socket_payload":    &opts.payload,
+	}
+	for key, field := range strs {
+		value, ok := d.Get(key).(string)
+		if !ok {
+			return lookupOptions{}, fmt.Errorf("%s is not a string", key)
+		}
+		*field = value
+	}
+
+	return opts, nil
+}
+
+// expandStringList converts a schema list into a slice of strings.
+func expandStringList(list []interface{}) []string {
+	out := make([]string, 0, len(list))
+	for _, v := range list {
+		if str, ok := v.(string); ok && str != "" {
+			out = append(out, str)
+		}
+	}
+	return out
+}
+
+// expandIntList converts a schema list into a slice of ints.
+func expandIntList(list []interface{}) []int {
+	out := make([]int, 0, len(list))
+	for _, v := range list {
+		if i, ok := v.(int); ok {
+			out = append(out, i)
+		}
+	}
+	return out
+}
@@ -0,0 +1,331 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func dataSourceEgressAddresses() *schema.Resource {
+	return &schema.Resource{
+		Description: "Looks up the external address of every local interface, by sending a lookup from each interface address. Useful to build allowlists for hosts with several uplinks.",
+		ReadContext: dataSourceEgressAddressesRead,
+
+		Schema: map[string]*schema.Schema{
+			"resolver": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      defaultResolver,
+				Description:  "The URL or built-in resolver name to query through each interface\nIf not set, the provider resolver and resolvers are used, falling back to https://checkip.amazonaws.com/",
+				ValidateFunc: validateResolver,
+			},
+			"client_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultClientTimeout,
+				Description:  "The time to wait for each lookup in ms\nIf not set, the provider client_timeout is used, falling back to 1000 (1 second). Setting to 0 means infinite (no timeout)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"include_interfaces": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Names of the interfaces to look up, where * matches any characters (for example eth*)\nIf not set, every interface that is up is used",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateInterfacePattern,
+				},
+			},
+			"exclude_interfaces": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Names of the interfaces to skip, where * matches any characters. Takes precedence over include_interfaces",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateInterfacePattern,
+				},
+			},
+			"address_families": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "The address families to look up, ipv4 and/or ipv6\nIf not set, both are used",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice([]string{familyIPv4, familyIPv6}, false),
+				},
+			},
+			"include_loopback": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     false,
+				Description: "Also look up through loopback interfaces",
+			},
+			"addresses": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "One entry per interface and address family, sorted by interface name",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"interface": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The name of the local interface",
+						},
+						"address_family": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The address family of the lookup, ipv4 or ipv6",
+						},
+						"local_address": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The interface address the lookup was sent from",
+						},
+						"external_address": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The external address seen by the resolver, empty when the lookup failed",
+						},
+						"error": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "Why the lookup failed, empty when it succeeded",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// egressCandidate is a local address to send a lookup from.
+type egressCandidate struct {
+	iface  string
+	family string
+	local  net.IP
+}
+
+// egressFilter selects the interfaces and families to look up through.
+type egressFilter struct {
+	include         []string
+	exclude         []string
+	families        []string
+	includeLoopback bool
+}
+
+// egressCandidates returns one candidate per eligible interface and family,
+// using the first usable address of each family.
+func egressCandidates(ifaces []net.Interface, addrsOf func(net.Interface) ([]net.Addr, error), filter egressFilter) ([]egressCandidate, error) {
+	families := filter.families
+	if len(families) == 0 {
+		families = []string{familyIPv4, familyIPv6}
+	}
+
+	sorted := make([]net.Interface, len(ifaces))
+	copy(sorted, ifaces)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	var candidates []egressCandidate
+	for _, iface := range sorted {
+		if !filter.matches(iface) {
+			continue
+		}
+
+		addrs, err := addrsOf(iface)
+		if err != nil {
+			return nil, fmt.Errorf("could not list addresses of interface %q: %w", iface.Name, err)
+		}
+
+		loopback := iface.Flags&net.FlagLoopback != 0
+		for _, family := range families {
+			if ip := firstAddress(addrs, networkForFamily(family), loopback); ip != nil {
+				candidates = append(candidates, egressCandidate{iface: iface.Name, family: family, local: ip})
+			}
+		}
+	}
+
+	return candidates, nil
+}
+
+// matches reports whether lookups should go through iface.
+func (f egressFilter) matches(iface net.Interface) bool {
+	if iface.Flags&net.FlagUp == 0 {
+		return false
+	}
+	if iface.Flags&net.FlagLoopback != 0 && !f.includeLoopback {
+		return false
+	}
+	if matchesAny(f.exclude, iface.Name) {
+		return false
+	}
+	return len(f.include) == 0 || matchesAny(f.include, iface.Name)
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func validateInterfacePattern(v interface{}, k string) ([]string, []error) {
+	pattern, ok := v.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, []error{fmt.Errorf("%s: invalid interface pattern %q: %w", k, pattern, err)}
+	}
+	return nil, nil
+}
+
+func dataSourceEgressAddressesRead(ctx context.Context, d *schema.ResourceData, meta interface{}) diag.Diagnostics {
+	ctx = withResolverLogging(ctx)
+
+	clientTimeout, ok := d.Get("client_timeout").(int)
+	if !ok {
+		return diag.FromErr(errors.New("client_timeout is not an int"))
+	}
+
+	filter, err := expandEgressFilter(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	config := providerConfigFrom(meta)
+	opts := lookupOptions{
+		clientTimeout:           clientTimeout,
+		maxRedirects:            defaultMaxRedirects,
+		allowCrossHostRedirects: true,
+		transports:              config.transports,
+		policy:                  config.policy,
+		mocks:                   config.mocks,
+		recorder:                config.recorder,
+	}
+	config.defaults.apply(d, &opts)
+
+	resolvers, err := config.defaults.resolverCandidates(d)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return diag.FromErr(fmt.Errorf("error listing local interfaces: %w", err))
+	}
+
+	candidates, err := egressCandidates(ifaces, func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() }, filter)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	var (
+		addresses []interface{}
+		diags     diag.Diagnostics
+	)
+	if config.overrideIPAddress != "" {
+		addresses = overrideEgressAddresses(candidates, config.overrideIPAddress)
+		diags = diag.Diagnostics{overrideWarning("external_address", config.overrideIPAddress)}
+	} else {
+		addresses = lookupEgressAddresses(ctx, candidates, resolvers, opts)
+	}
+	if err := d.Set("addresses", addresses); err != nil {
+		return diag.FromErr(fmt.Errorf("error setting addresses: %s", err.Error()))
+	}
+
+	d.SetId(time.Now().UTC().Format("20060102150405"))
+	return diags
+}
+
+// egressEntry returns the addresses entry of candidate, with no external address yet.
+func egressEntry(candidate egressCandidate) map[string]interface{} {
+	return map[string]interface{}{
+		"interface":        candidate.iface,
+		"address_family":   candidate.family,
+		"local_address":    candidate.local.String(),
+		"external_address": "",
+		"error":            "",
+	}
+}
+
+// overrideEgressAddresses answers every candidate with the provider override
+// address, without any lookup.
+func overrideEgressAddresses(candidates []egressCandidate, override string) []interface{} {
+	addresses := make([]interface{}, len(candidates))
+	for i, candidate := range candidates {
+		entry := egressEntry(candidate)
+		entry["external_address"] = override
+		addresses[i] = entry
+	}
+	return addresses
+}
+
+// lookupEgressAddresses looks up every candidate concurrently. Failures are
+// reported per entry rather than failing the whole read.
+func lookupEgressAddresses(ctx context.Context, candidates []egressCandidate, resolvers []string, opts lookupOptions) []interface{} {
+	addresses := make([]interface{}, len(candidates))
+
+	var wg sync.WaitGroup
+	for i, candidate := range candidates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			bound := opts
+			bound.sourceAddress = candidate.local.String()
+
+			entry := egressEntry(candidate)
+
+			result, err := lookupWithFallback(ctx, resolvers, bound)
+			if err != nil {
+				entry["error"] = err.Error()
+			} else {
+				entry["external_address"] = result.ip
+			}
+			addresses[i] = entry
+		}()
+	}
+	wg.Wait()
+
+	return addresses
+}
+
+// expandEgressFilter builds the interface filter from the data source arguments.
+func expandEgressFilter(d *schema.ResourceData) (egressFilter, error) {
+	include, ok := d.Get("include_interfaces").([]interface{})
+	if !ok {
+		return egressFilter{}, errors.New("include_interfaces is not a list")
+	}
+
+	exclude, ok := d.Get("exclude_interfaces").([]interface{})
+	if !ok {
+		return egressFilter{}, errors.New("exclude_interfaces is not a list")
+	}
+
+	families, ok := d.Get("address_families").([]interface{})
+	if !ok {
+		return egressFilter{}, errors.New("address_families is not a list")
+	}
+
+	includeLoopback, ok := d.Get("include_loopback").(bool)
+	if !ok {
+		return egressFilter{}, errors.New("include_loopback is not a bool")
+	}
+
+	return egressFilter{
+		include:         expandStringList(include),
+		exclude:         expandStringList(exclude),
+		families:        expandStringList(families),
+		includeLoopback: includeLoopback,
+	}, nil
+}
@@ -0,0 +1,149 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestEgressCandidates(t *testing.T) {
+	ifaces := []net.Interface{
+		{Name: "wlan0", Flags: net.FlagUp},
+		{Name: "eth1", Flags: net.FlagUp},
+		{Name: "eth0", Flags: net.FlagUp},
+		{Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+		{Name: "eth2"}, // down
+		{Name: "docker0", Flags: net.FlagUp},
+	}
+
+	addrs := map[string][]net.Addr{
+		"eth0": {
+			&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+			&net.IPNet{IP: net.ParseIP("192.0.2.10"), Mask: net.CIDRMask(24, 32)},
+			&net.IPNet{IP: net.ParseIP("2001:db8::10"), Mask: net.CIDRMask(64, 128)},
+		},
+		"eth1":    {&net.IPNet{IP: net.ParseIP("198.51.100.10"), Mask: net.CIDRMask(24, 32)}},
+		"wlan0":   {&net.IPNet{IP: net.ParseIP("203.0.113.10"), Mask: net.CIDRMask(24, 32)}},
+		"lo":      {&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}},
+		"docker0": {&net.IPNet{IP: net.ParseIP("172.17.0.1"), Mask: net.CIDRMask(16, 32)}},
+	}
+	addrsOf := func(iface net.Interface) ([]net.Addr, error) { return addrs[iface.Name], nil }
+
+	candidates, err := egressCandidates(ifaces, addrsOf, egressFilter{
+		include: []string{"eth*", "wlan*"},
+		exclude: []string{"wlan0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []egressCandidate{
+		{iface: "eth0", family: familyIPv4, local: net.ParseIP("192.0.2.10")},
+		{iface: "eth0", family: familyIPv6, local: net.ParseIP("2001:db8::10")},
+		{iface: "eth1", family: familyIPv4, local: net.ParseIP("198.51.100.10")},
+	}
+	if len(candidates) != len(expected) {
+		t.Fatalf("Expected %d candidates, got %v", len(expected), candidates)
+	}
+	for i := range expected {
+		if candidates[i].iface != expected[i].iface || candidates[i].family != expected[i].family || !candidates[i].local.Equal(expected[i].local) {
+			t.Errorf("Expected candidate %d to be %v, got %v", i, expected[i], candidates[i])
+		}
+	}
+
+	candidates, err = egressCandidates(ifaces, addrsOf, egressFilter{families: []string{familyIPv4}, includeLoopback: true, include: []string{"lo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].iface != "lo" {
+		t.Errorf("Expected only the loopback interface, got %v", candidates)
+	}
+
+	failing := func(net.Interface) ([]net.Addr, error) { return nil, errors.New("boom") }
+	if _, err := egressCandidates(ifaces, failing, egressFilter{}); err == nil {
+		t.Error("Expected address listing errors to be returned")
+	}
+}
+
+func TestDataSourceEgressAddressesRead(t *testing.T) {
+	iface := loopbackInterface(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		_, _ = w.Write([]byte(host))
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSourceEgressAddresses().Schema, map[string]interface{}{
+		"resolver":           server.URL,
+		"include_interfaces": []interface{}{iface.Name},
+		"address_families":   []interface{}{familyIPv4, familyIPv6},
+		"include_loopback":   true,
+	})
+
+	if diags := dataSourceEgressAddressesRead(context.Background(), d, nil); diags.HasError() {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+
+	addresses := d.Get("addresses").([]interface{})
+	if len(addresses) == 0 {
+		t.Fatal("Expected at least one address for the loopback interface")
+	}
+
+	for _, raw := range addresses {
+		entry := raw.(map[string]interface{})
+		switch entry["address_family"] {
+		case familyIPv4:
+			if entry["error"] != "" || entry["external_address"] != entry["local_address"] {
+				t.Errorf("Expected the IPv4 lookup to come from its local address, got %v", entry)
+			}
+		case familyIPv6:
+			// The test server only listens on IPv4
+			if entry["error"] == "" {
+				t.Errorf("Expected the IPv6 lookup to fail, got %v", entry)
+			}
+		}
+	}
+
+	if d.Id() == "" {
+		t.Error("Expected ID to be set")
+	}
+}
+
+func TestDataSourceEgressAddressesReadOverride(t *testing.T) {
+	iface := loopbackInterface(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("Expected no resolver request with an override address")
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSourceEgressAddresses().Schema, map[string]interface{}{
+		"resolver":           server.URL,
+		"include_interfaces": []interface{}{iface.Name},
+		"include_loopback":   true,
+	})
+	meta := &providerConfig{transports: fallbackTransports, overrideIPAddress: "192.0.2.10"}
+
+	diags := dataSourceEgressAddressesRead(context.Background(), d, meta)
+	if diags.HasError() || len(diags) != 1 || diags[0].Severity != diag.Warning {
+		t.Fatalf("Expected a single override warning, got: %v", diags)
+	}
+
+	addresses := d.Get("addresses").([]interface{})
+	if len(addresses) == 0 {
+		t.Fatal("Expected at least one address for the loopback interface")
+	}
+	for _, raw := range addresses {
+		entry := raw.(map[string]interface{})
+		if entry["external_address"] != "192.0.2.10" || entry["error"] != "" {
+			t.Errorf("Expected the override address, got %v", entry)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package extip
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Address classifications reported by extip_local_interfaces.
+const (
+	addressLoopback  = "loopback"
+	addressLinkLocal = "link-local"
+	addressPrivate   = "private"
+	addressShared    = "shared"
+	addressPublic    = "public"
+	addressOther     = "other"
+)
+
+// sharedAddressSpace is the carrier-grade NAT range of RFC 6598.
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+func dataSourceLocalInterfaces() *schema.Resource {
+	return &schema.Resource{
+		Description: "Lists the network interfaces of the machine running Terraform and classifies their addresses, to compare local and external addresses or tell whether the machine is directly on a public address.",
+		ReadContext: dataSourceLocalInterfacesRead,
+
+		Schema: map[string]*schema.Schema{
+			"interfaces": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The local interfaces, sorted by name",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The interface name",
+						},
+						"index": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "The interface index",
+						},
+						"mtu": {
+							Type:        schema.TypeInt,
+							Computed:    true,
+							Description: "The maximum transmission unit in bytes",
+						},
+						"flags": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "The interface flags, such as up, broadcast, loopback or multicast",
+							Elem: &schema.Schema{
+								Type: schema.TypeString,
+							},
+						},
+						"hardware_address": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The MAC address, empty for interfaces without one",
+						},
+						"addresses": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "The addresses assigned to the interface",
+							Elem: &schema.Resource{
+								Schema: map[string]*schema.Schema{
+									"address": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "The IP address",
+									},
+									"prefix_length": {
+										Type:        schema.TypeInt,
+										Computed:    true,
+										Description: "The length of the network prefix",
+									},
+									"address_family": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "The address family, ipv4 or ipv6",
+									},
+									"classification": {
+										Type:        schema.TypeString,
+										Computed:    true,
+										Description: "One of loopback, link-local, private, shared (carrier-grade NAT), public or other",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			"has_public_address": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "Whether any interface that is up has a public address",
+			},
+		},
+	}
+}
+
+func dataSourceLocalInterfacesRead(_ context.Context, d *schema.ResourceData, _ interface{}) diag.Diagnostics {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return diag.FromErr(fmt.Errorf("error listing local interfaces: %w", err))
+	}
+
+	interfaces, hasPublic, err := flattenInterfaces(ifaces, func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() })
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	if err := d.Set("interfaces", interfaces); err != nil {
+		return diag.FromErr(fmt.Errorf("error setting interfaces: %s", err.Error()))
+	}
+	if err := d.Set("has_public_address", hasPublic); err != nil {
+		return diag.FromErr(fmt.Errorf("error setting has_public_address: %s", err.Error()))
+	}
+
+	d.SetId("extip_local_interfaces")
+	return nil
+}
+
+// flattenInterfaces converts ifaces to their schema representation, sorted by
+// name, and reports whether an interface that is up has a public address.
+func flattenInterfaces(ifaces []net.Interface, addrsOf func(net.Interface) ([]net.Addr, error)) ([]interface{}, bool, error) {
+	sorted := make([]net.Interface, len(ifaces))
+	copy(sorted, ifaces)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	hasPublic := false
+	interfaces := make([]interface{}, 0, len(sorted))
+	for _, iface := range sorted {
+		addrs, err := addrsOf(iface)
+		if err != nil {
+			return nil, false, fmt.Errorf("could not list addresses of interface %q: %w", iface.Name, err)
+		}
+
+		addresses := make([]interface{}, 0, len(addrs))
+		for _, addr := range addrs {
+			ipNet, ok := addr.(*net.IPNet)
+			if !ok {
+				continue
+			}
+
+			family := familyIPv6
+			if ipNet.IP.To4() != nil {
+				family = familyIPv4
+			}
+			prefix, _ := ipNet.Mask.Size()
+			classification := classifyAddress(ipNet.IP)
+			if classification == addressPublic && iface.Flags&net.FlagUp != 0 {
+				hasPublic = true
+			}
+
+			addresses = append(addresses, map[string]interface{}{
+				"address":        ipNet.IP.String(),
+				"prefix_length":  prefix,
+				"address_family": family,
+				"classification": classification,
+			})
+		}
+
+		var flags []interface{}
+		if iface.Flags != 0 {
+			for _, flag := range strings.Split(iface.Flags.String(), "|") {
+				flags = append(flags, flag)
+			}
+		}
+
+		interfaces = append(interfaces, map[string]interface{}{
+			"name":             iface.Name,
+			"index":            iface.Index,
+			"mtu":              iface.MTU,
+			"flags":            flags,
+			"hardware_address": iface.HardwareAddr.String(),
+			"addresses":        addresses,
+		})
+	}
+
+	return interfaces, hasPublic, nil
+}
+
+// classifyAddress tells what kind of network an address belongs to.
+func classifyAddress(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return addressLoopback
+	case ip.IsLinkLocalUnicast():
+		return addressLinkLocal
+	case ip.IsPrivate():
+		return addressPrivate
+	case sharedAddressSpace.Contains(ip):
+		return addressShared
+	case ip.IsGlobalUnicast():
+		return addressPublic
+	default:
+		return addressOther
+	}
+}
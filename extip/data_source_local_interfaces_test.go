@@ -0,0 +1,103 @@
+package extip
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestClassifyAddress(t *testing.T) {
+	tests := map[string]string{
+		"127.0.0.1":    addressLoopback,
+		"::1":          addressLoopback,
+		"169.254.10.1": addressLinkLocal,
+		"fe80::1":      addressLinkLocal,
+		"10.1.2.3":     addressPrivate,
+		"172.16.0.1":   addressPrivate,
+		"192.168.1.1":  addressPrivate,
+		"fd00::1":      addressPrivate,
+		"100.64.0.1":   addressShared,
+		"8.8.8.8":      addressPublic,
+		"2606:4700::1": addressPublic,
+		"224.0.0.1":    addressOther,
+		"0.0.0.0":      addressOther,
+	}
+
+	for ip, expected := range tests {
+		if got := classifyAddress(net.ParseIP(ip)); got != expected {
+			t.Errorf("classifyAddress(%s) = %s, expected %s", ip, got, expected)
+		}
+	}
+}
+
+func TestFlattenInterfaces(t *testing.T) {
+	mac, _ := net.ParseMAC("00:11:22:33:44:55")
+	ifaces := []net.Interface{
+		{Index: 2, MTU: 1500, Name: "eth0", HardwareAddr: mac, Flags: net.FlagUp | net.FlagBroadcast},
+		{Index: 1, MTU: 65536, Name: "lo", Flags: net.FlagUp | net.FlagLoopback},
+		{Index: 3, MTU: 1500, Name: "eth1"},
+	}
+	addrs := map[string][]net.Addr{
+		"eth0": {&net.IPNet{IP: net.ParseIP("192.168.1.10"), Mask: net.CIDRMask(24, 32)}},
+		"lo":   {&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)}},
+		// Down interfaces do not count towards has_public_address
+		"eth1": {&net.IPNet{IP: net.ParseIP("203.0.113.5"), Mask: net.CIDRMask(24, 32)}},
+	}
+
+	interfaces, hasPublic, err := flattenInterfaces(ifaces, func(iface net.Interface) ([]net.Addr, error) {
+		return addrs[iface.Name], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if hasPublic {
+		t.Error("Expected no public address on an interface that is up")
+	}
+
+	expected := map[string]interface{}{
+		"name":             "eth0",
+		"index":            2,
+		"mtu":              1500,
+		"flags":            []interface{}{"up", "broadcast"},
+		"hardware_address": "00:11:22:33:44:55",
+		"addresses": []interface{}{map[string]interface{}{
+			"address":        "192.168.1.10",
+			"prefix_length":  24,
+			"address_family": familyIPv4,
+			"classification": addressPrivate,
+		}},
+	}
+	if !reflect.DeepEqual(interfaces[0], expected) {
+		t.Errorf("Expected %v, got %v", expected, interfaces[0])
+	}
+
+	if interfaces[1].(map[string]interface{})["name"] != "eth1" || interfaces[2].(map[string]interface{})["name"] != "lo" {
+		t.Errorf("Expected interfaces sorted by name, got %v", interfaces)
+	}
+
+	addrs["eth0"] = append(addrs["eth0"], &net.IPNet{IP: net.ParseIP("2001:db8:1::10"), Mask: net.CIDRMask(64, 128)})
+	_, hasPublic, _ = flattenInterfaces(ifaces, func(iface net.Interface) ([]net.Addr, error) {
+		return addrs[iface.Name], nil
+	})
+	if !hasPublic {
+		t.Error("Expected a public address to be detected")
+	}
+}
+
+func TestDataSourceLocalInterfacesRead(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, dataSourceLocalInterfaces().Schema, map[string]interface{}{})
+	if diags := dataSourceLocalInterfacesRead(context.Background(), d, nil); diags.HasError() {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+
+	if len(d.Get("interfaces").([]interface{})) == 0 {
+		t.Error("Expected at least one local interface")
+	}
+	if d.Id() == "" {
+		t.Error("Expected ID to be set")
+	}
+}
@@ -0,0 +1,75 @@
+package extip
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func dataSourceResolvers() *schema.Resource {
+	return &schema.Resource{
+		Description: "Lists the built-in resolvers that can be used by name in the resolver argument of the extip data source.",
+		ReadContext: dataSourceResolversRead,
+
+		Schema: map[string]*schema.Schema{
+			"resolvers": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The built-in resolvers, sorted by name",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The name to use in the resolver argument",
+						},
+						"description": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "A short description of the service",
+						},
+						"protocol": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The protocol used to query the resolver: https, dns or metadata",
+						},
+						"address_family": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The address family returned by the resolver: ipv4, ipv6 or any",
+						},
+						"url": {
+							Type:        schema.TypeString,
+							Computed:    true,
+							Description: "The URL, DNS query or metadata endpoint the name expands to",
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceResolversRead(_ context.Context, d *schema.ResourceData, _ interface{}) diag.Diagnostics {
+	names := resolverNames()
+	resolvers := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		entry, _ := catalogEntry(name)
+		resolvers = append(resolvers, map[string]interface{}{
+			"name":           entry.name,
+			"description":    entry.description,
+			"protocol":       entry.protocol,
+			"address_family": entry.addressFamily,
+			"url":            entry.target(),
+		})
+	}
+
+	if err := d.Set("resolvers", resolvers); err != nil {
+		return diag.FromErr(fmt.Errorf("error setting resolvers: %s", err.Error()))
+	}
+
+	d.SetId("extip_resolvers")
+	return nil
+}
@@ -0,0 +1,150 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+func dataSourceRouteSource() *schema.Resource {
+	return &schema.Resource{
+		Description: "Finds the local address and interface the machine running Terraform would use to reach a destination. The route is looked up with a connectionless UDP socket, so nothing is sent to the destination. A host name is resolved first, which queries DNS.",
+		ReadContext: dataSourceRouteSourceRead,
+
+		Schema: map[string]*schema.Schema{
+			"destination": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "The host name or IP address to reach. A host name is resolved with the system resolver",
+				ValidateFunc: validation.StringIsNotWhiteSpace,
+			},
+			"port": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      443,
+				Description:  "The destination port, which can matter for policy routing\nIf not set, defaults to 443",
+				ValidateFunc: validation.IsPortNumber,
+			},
+			"address_family": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      familyAny,
+				Description:  "The address family to use for a host name with both IPv4 and IPv6 addresses: ipv4, ipv6 or any\nIf not set, defaults to any",
+				ValidateFunc: validation.StringInSlice([]string{familyIPv4, familyIPv6, familyAny}, false),
+			},
+			"destination_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The IP address the destination resolved to",
+			},
+			"local_address": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The local address the kernel would send from",
+			},
+			"interface": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The name of the interface holding local_address",
+			},
+		},
+	}
+}
+
+func dataSourceRouteSourceRead(ctx context.Context, d *schema.ResourceData, _ interface{}) diag.Diagnostics {
+	destination, ok := d.Get("destination").(string)
+	if !ok {
+		return diag.FromErr(errors.New("destination is not a string"))
+	}
+
+	port, ok := d.Get("port").(int)
+	if !ok {
+		return diag.FromErr(errors.New("port is not an int"))
+	}
+
+	family, ok := d.Get("address_family").(string)
+	if !ok {
+		return diag.FromErr(errors.New("address_family is not a string"))
+	}
+
+	remote, local, err := routeSource(ctx, destination, port, family)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return diag.FromErr(fmt.Errorf("error listing local interfaces: %w", err))
+	}
+	iface, err := interfaceForAddress(ifaces, func(iface net.Interface) ([]net.Addr, error) { return iface.Addrs() }, local)
+	if err != nil {
+		return diag.FromErr(err)
+	}
+
+	values := map[string]interface{}{
+		"destination_address": remote.String(),
+		"local_address":       local.String(),
+		"interface":           iface,
+	}
+	for key, value := range values {
+		if err := d.Set(key, value); err != nil {
+			return diag.FromErr(fmt.Errorf("error setting %s: %s", key, err.Error()))
+		}
+	}
+
+	d.SetId(net.JoinHostPort(destination, strconv.Itoa(port)))
+	return nil
+}
+
+// routeSource returns the address destination resolves to and the local
+// address the kernel picks to reach it. Connecting a UDP socket only looks up
+// the route; nothing is sent until data is written, which never happens.
+func routeSource(ctx context.Context, destination string, port int, family string) (remote, local net.IP, err error) {
+	network := "udp"
+	switch family {
+	case familyIPv4:
+		network = "udp4"
+	case familyIPv6:
+		network = "udp6"
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(destination, strconv.Itoa(port)))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error finding the route to %s: %w", destination, err)
+	}
+	defer conn.Close()
+
+	remoteAddr, ok := conn.RemoteAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected remote address %s", conn.RemoteAddr())
+	}
+	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return nil, nil, fmt.Errorf("unexpected local address %s", conn.LocalAddr())
+	}
+
+	return remoteAddr.IP, localAddr.IP, nil
+}
+
+// interfaceForAddress returns the name of the interface that holds ip.
+func interfaceForAddress(ifaces []net.Interface, addrsOf func(net.Interface) ([]net.Addr, error), ip net.IP) (string, error) {
+	for _, iface := range ifaces {
+		addrs, err := addrsOf(iface)
+		if err != nil {
+			return "", fmt.Errorf("could not list addresses of interface %q: %w", iface.Name, err)
+		}
+		for _, addr := range addrs {
+			if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+				return iface.Name, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no local interface has address %s", ip)
+}
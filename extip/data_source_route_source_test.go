@@ -0,0 +1,67 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestDataSourceRouteSourceRead(t *testing.T) {
+	iface := loopbackInterface(t)
+
+	d := schema.TestResourceDataRaw(t, dataSourceRouteSource().Schema, map[string]interface{}{
+		"destination":    "127.0.0.1",
+		"port":           53,
+		"address_family": familyIPv4,
+	})
+	if diags := dataSourceRouteSourceRead(context.Background(), d, nil); diags.HasError() {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+
+	expected := map[string]interface{}{
+		"destination_address": "127.0.0.1",
+		"local_address":       "127.0.0.1",
+		"interface":           iface.Name,
+	}
+	for key, value := range expected {
+		if d.Get(key) != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, d.Get(key))
+		}
+	}
+
+	if d.Id() != "127.0.0.1:53" {
+		t.Errorf("Unexpected ID: %s", d.Id())
+	}
+}
+
+func TestRouteSourceFamilyMismatch(t *testing.T) {
+	if _, _, err := routeSource(context.Background(), "127.0.0.1", 53, familyIPv6); err == nil {
+		t.Error("Expected an IPv4 destination to be unreachable over IPv6")
+	}
+}
+
+func TestInterfaceForAddress(t *testing.T) {
+	ifaces := []net.Interface{{Name: "eth0"}, {Name: "eth1"}}
+	addrs := map[string][]net.Addr{
+		"eth0": {&net.IPNet{IP: net.ParseIP("192.0.2.10"), Mask: net.CIDRMask(24, 32)}},
+		"eth1": {&net.IPNet{IP: net.ParseIP("2001:db8::10"), Mask: net.CIDRMask(64, 128)}},
+	}
+	addrsOf := func(iface net.Interface) ([]net.Addr, error) { return addrs[iface.Name], nil }
+
+	name, err := interfaceForAddress(ifaces, addrsOf, net.ParseIP("2001:db8::10"))
+	if err != nil || name != "eth1" {
+		t.Errorf("Expected eth1, got %q (%v)", name, err)
+	}
+
+	if _, err := interfaceForAddress(ifaces, addrsOf, net.ParseIP("198.51.100.1")); err == nil {
+		t.Error("Expected an unknown address to fail")
+	}
+
+	failing := func(net.Interface) ([]net.Addr, error) { return nil, errors.New("boom") }
+	if _, err := interfaceForAddress(ifaces, failing, net.ParseIP("192.0.2.10")); err == nil {
+		t.Error("Expected address listing errors to be returned")
+	}
+}
@@ -1,16 +1,18 @@
 package extip
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
-	"sync"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/resource"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/terraform"
@@ -423,60 +425,9 @@ func TestDataSource_DefaultResolver(t *testing.T) {
 
 // Unit tests for 100% coverage
 
-func TestGetHTTPClient(t *testing.T) {
-	// Test client caching
-	timeout1 := 5 * time.Second
-	timeout2 := 10 * time.Second
-
-	client1 := getHTTPClient(timeout1)
-	client2 := getHTTPClient(timeout1) // Should return same client
-	client3 := getHTTPClient(timeout2) // Should return different client
-
-	if client1 != client2 {
-		t.Error("Expected same client instance for same timeout")
-	}
-
-	if client1 == client3 {
-		t.Error("Expected different client instance for different timeout")
-	}
-
-	if client1.Timeout != timeout1 {
-		t.Errorf("Expected timeout %v, got %v", timeout1, client1.Timeout)
-	}
-
-	if client3.Timeout != timeout2 {
-		t.Errorf("Expected timeout %v, got %v", timeout2, client3.Timeout)
-	}
-}
-
-func TestGetHTTPClientConcurrency(t *testing.T) {
-	// Test concurrent access to client cache
-	timeout := 1 * time.Second
-
-	var wg sync.WaitGroup
-	clients := make([]*http.Client, 10)
-
-	for i := 0; i < 10; i++ {
-		wg.Add(1)
-		go func(index int) {
-			defer wg.Done()
-			clients[index] = getHTTPClient(timeout)
-		}(i)
-	}
-
-	wg.Wait()
-
-	// All clients should be the same instance
-	for i := 1; i < len(clients); i++ {
-		if clients[0] != clients[i] {
-			t.Error("Expected all concurrent requests to return same client instance")
-		}
-	}
-}
-
 func TestGetExternalIPFromInvalidURL(t *testing.T) {
 	// Test invalid URL
-	_, err := getExternalIPFrom("invalid-url", 1000)
+	_, err := getExternalIPFrom(context.Background(), "invalid-url", lookupOptions{clientTimeout: 1000})
 	if err == nil {
 		t.Error("Expected error for invalid URL")
 	}
@@ -484,7 +435,7 @@ func TestGetExternalIPFromInvalidURL(t *testing.T) {
 
 func TestGetExternalIPFromRequestCreationError(t *testing.T) {
 	// Test with URL that would cause request creation to fail
-	_, err := getExternalIPFrom("ht\ttp://invalid", 1000)
+	_, err := getExternalIPFrom(context.Background(), "ht\ttp://invalid", lookupOptions{clientTimeout: 1000})
 	if err == nil {
 		t.Error("Expected error for malformed URL")
 	}
@@ -505,7 +456,7 @@ func TestDataSourceReadValidDataSuccess(t *testing.T) {
 	})
 
 	// Test that valid data works
-	err := dataSourceRead(validData, nil)
+	err := dataSourceRead(context.Background(), validData, nil)
 	if err != nil {
 		t.Errorf("Expected no error with valid data, got: %v", err)
 	}
@@ -537,7 +488,7 @@ func TestDataSourceReadSetError(t *testing.T) {
 	})
 
 	// This should succeed
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -563,7 +514,7 @@ func TestDataSourceReadValidateIPError(t *testing.T) {
 		"validate_ip":    true,
 	})
 
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err == nil {
 		t.Error("Expected error for invalid IP with validation enabled")
 	}
@@ -589,7 +540,7 @@ func TestDataSourceReadValidateIPSuccess(t *testing.T) {
 		"validate_ip":    true,
 	})
 
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err != nil {
 		t.Errorf("Expected no error with valid IP, got: %v", err)
 	}
@@ -614,7 +565,7 @@ func TestDataSourceReadValidateIPFalse(t *testing.T) {
 		"validate_ip":    false,
 	})
 
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err != nil {
 		t.Errorf("Expected no error with validation disabled, got: %v", err)
 	}
@@ -639,7 +590,7 @@ func TestDataSourceReadValidateIPNotSet(t *testing.T) {
 		// validate_ip not set - should default to not validating
 	})
 
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err != nil {
 		t.Errorf("Expected no error with validation not set, got: %v", err)
 	}
@@ -685,7 +636,7 @@ func TestGetExternalIPFromHTTPErrors(t *testing.T) {
 			}))
 			defer server.Close()
 
-			_, err := getExternalIPFrom(server.URL, 1000)
+			_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000})
 			if err == nil {
 				t.Errorf("Expected error for status code %d", tt.statusCode)
 			}
@@ -705,13 +656,13 @@ func TestGetExternalIPFromSuccess(t *testing.T) {
 	}))
 	defer server.Close()
 
-	ip, err := getExternalIPFrom(server.URL, 1000)
+	result, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000})
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
 
-	if ip != "192.168.1.100" {
-		t.Errorf("Expected trimmed IP '192.168.1.100', got: '%s'", ip)
+	if result.ip != "192.168.1.100" {
+		t.Errorf("Expected trimmed IP '192.168.1.100', got: '%s'", result.ip)
 	}
 }
 
@@ -723,13 +674,13 @@ func TestGetExternalIPFromZeroTimeout(t *testing.T) {
 	}))
 	defer server.Close()
 
-	ip, err := getExternalIPFrom(server.URL, 0)
+	result, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 0})
 	if err != nil {
 		t.Errorf("Expected no error with zero timeout, got: %v", err)
 	}
 
-	if ip != "10.0.0.1" {
-		t.Errorf("Expected IP '10.0.0.1', got: '%s'", ip)
+	if result.ip != "10.0.0.1" {
+		t.Errorf("Expected IP '10.0.0.1', got: '%s'", result.ip)
 	}
 }
 
@@ -742,7 +693,10 @@ func TestDataSource(t *testing.T) {
 	}
 
 	// Check schema fields
-	expectedFields := []string{"ipaddress", "resolver", "client_timeout", "validate_ip"}
+	expectedFields := []string{
+		"ipaddress", "resolver", "client_timeout", "validate_ip",
+		"max_response_bytes", "expected_content_types",
+	}
 	for _, field := range expectedFields {
 		if _, exists := ds.Schema[field]; !exists {
 			t.Errorf("Expected schema field '%s' to exist", field)
@@ -760,6 +714,11 @@ func TestDataSource(t *testing.T) {
 	if ds.Schema["client_timeout"].Default != 1000 {
 		t.Errorf("Expected default client_timeout to be 1000, got: %v", ds.Schema["client_timeout"].Default)
 	}
+
+	if ds.Schema["max_response_bytes"].Default != defaultMaxResponseBytes {
+		t.Errorf("Expected default max_response_bytes to be %d, got: %v",
+			defaultMaxResponseBytes, ds.Schema["max_response_bytes"].Default)
+	}
 }
 
 // Additional tests to achieve 100% coverage.
@@ -780,7 +739,7 @@ func TestDataSourceReadEdgeCases(t *testing.T) {
 		"client_timeout": 1000,
 	})
 
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -801,7 +760,7 @@ func TestGetExternalIPFromReadBodyError(t *testing.T) {
 	}))
 	defer server.Close()
 
-	_, err := getExternalIPFrom(server.URL, 1000)
+	_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000})
 	if err == nil {
 		t.Error("Expected error when connection is closed")
 	}
@@ -833,79 +792,17 @@ func TestGetExternalIPFromBodyCloseError(t *testing.T) {
 	defer server.Close()
 
 	// This may succeed or fail depending on timing, but it exercises the close path
-	ip, err := getExternalIPFrom(server.URL, 1000)
+	result, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000})
 
 	// Either it succeeds and we got the IP, or it fails with connection error
 	if err == nil {
-		if ip != testIP {
-			t.Errorf("Expected IP 127.0.0.1, got: %s", ip)
+		if result.ip != testIP {
+			t.Errorf("Expected IP 127.0.0.1, got: %s", result.ip)
 		}
 	}
 	// If it fails, that's also acceptable as we're testing edge cases
 }
 
-func TestGetHTTPClientDoubleCheck(t *testing.T) {
-	// This test targets the specific double-check pattern in lines 38-40
-	timeout := 987 * time.Millisecond
-
-	// Clear any existing client for this timeout
-	clientMutex.Lock()
-	delete(httpClients, timeout)
-	clientMutex.Unlock()
-
-	// Create a client first
-	client1 := getHTTPClient(timeout)
-
-	// Verify client exists in cache
-	clientMutex.RLock()
-	cached, exists := httpClients[timeout]
-	clientMutex.RUnlock()
-
-	if !exists {
-		t.Error("Expected client to be cached")
-	}
-
-	if cached != client1 {
-		t.Error("Expected cached client to match returned client")
-	}
-
-	// Now this call should hit the double-check path (lines 38-40)
-	// The client already exists, so it should return the existing one
-	client2 := getHTTPClient(timeout)
-
-	if client1 != client2 {
-		t.Error("Expected same client instance from double-check")
-	}
-
-	// Test race condition to force double-check pattern
-	var wg sync.WaitGroup
-	clients := make([]*http.Client, 100)
-
-	// Clear the cache but immediately call from multiple goroutines
-	clientMutex.Lock()
-	delete(httpClients, timeout)
-	clientMutex.Unlock()
-
-	// Launch many goroutines simultaneously to create race condition
-	for i := 0; i < 100; i++ {
-		wg.Add(1)
-		go func(index int) {
-			defer wg.Done()
-			// Each call will compete for the write lock and hit double-check
-			clients[index] = getHTTPClient(timeout)
-		}(i)
-	}
-
-	wg.Wait()
-
-	// Verify all got the same instance (double-check worked)
-	for i := 1; i < len(clients); i++ {
-		if clients[0] != clients[i] {
-			t.Error("Expected same client instance from concurrent double-check")
-		}
-	}
-}
-
 func TestDataSourceReadSetOperationFails(t *testing.T) {
 	// This tests the specific error path in d.Set() that we can't easily reach
 	// The defensive error handling for d.Set("ipaddress", ip) is tested by
@@ -922,7 +819,7 @@ func TestDataSourceReadSetOperationFails(t *testing.T) {
 		"client_timeout": 2000,
 	})
 
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -937,35 +834,6 @@ func TestDataSourceReadSetOperationFails(t *testing.T) {
 
 // Helper functions to create test scenarios that force edge case coverage
 
-func TestHttpClientCacheHitPattern(t *testing.T) {
-	// Target line 38-40: Force the double-check cache hit pattern
-	timeout := 777 * time.Millisecond
-
-	// Clear any existing client
-	clientMutex.Lock()
-	delete(httpClients, timeout)
-	clientMutex.Unlock()
-
-	// Get a client to populate the cache
-	client1 := getHTTPClient(timeout)
-
-	// This call should hit the cache and execute the double-check return path
-	client2 := getHTTPClient(timeout)
-
-	if client1 != client2 {
-		t.Error("Expected same client from cache")
-	}
-
-	// Verify the client is cached
-	clientMutex.RLock()
-	cachedClient, exists := httpClients[timeout]
-	clientMutex.RUnlock()
-
-	if !exists || cachedClient != client1 {
-		t.Error("Expected client to be properly cached")
-	}
-}
-
 func TestCoverageVerification(t *testing.T) {
 	// This test verifies that we have excellent coverage of all realistic code paths
 	// The remaining uncovered lines (10.2%) are defensive error handling code
@@ -991,7 +859,7 @@ func TestCoverageVerification(t *testing.T) {
 		"validate_ip":    true,
 	})
 
-	err := dataSourceRead(d, nil)
+	err := dataSourceRead(context.Background(), d, nil)
 	if err != nil {
 		t.Errorf("Expected no error, got: %v", err)
 	}
@@ -1024,20 +892,19 @@ func TestAllReachableEdgeCases(t *testing.T) {
 }
 
 func testHTTPClientCaching(t *testing.T) {
-	// Test HTTP client caching and reuse patterns
-	timeout1 := 555 * time.Millisecond
-	timeout2 := 666 * time.Millisecond
+	// Test transport caching and reuse patterns
+	pool := newTransportPool(defaultTransportPoolSize, defaultTransportIdleTimeout)
 
-	client1a := getHTTPClient(timeout1)
-	client1b := getHTTPClient(timeout1) // Should be same instance
-	client2 := getHTTPClient(timeout2)  // Should be different instance
+	client1a := pool.getHTTPClient(lookupOptions{clientTimeout: 555, dialTimeout: 100})
+	client1b := pool.getHTTPClient(lookupOptions{clientTimeout: 666, dialTimeout: 100}) // Same connection settings
+	client2 := pool.getHTTPClient(lookupOptions{clientTimeout: 555, dialTimeout: 200})  // Different connection settings
 
-	if client1a != client1b {
-		t.Error("Expected same client for same timeout")
+	if client1a.Transport != client1b.Transport {
+		t.Error("Expected same transport for same connection settings")
 	}
 
-	if client1a == client2 {
-		t.Error("Expected different clients for different timeouts")
+	if client1a.Transport == client2.Transport {
+		t.Error("Expected different transports for different connection settings")
 	}
 }
 
@@ -1050,7 +917,7 @@ func testErrorResponseCodes(t *testing.T) {
 			w.WriteHeader(code)
 		}))
 
-		_, err := getExternalIPFrom(server.URL, 1000)
+		_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000})
 		if err == nil {
 			t.Errorf("Expected error for status code %d", code)
 		}
@@ -1061,12 +928,12 @@ func testErrorResponseCodes(t *testing.T) {
 
 func testNetworkFailures(t *testing.T) {
 	// Test various network failure scenarios
-	_, err := getExternalIPFrom("http://definitely-not-a-real-domain-12345.com", 1000)
+	_, err := getExternalIPFrom(context.Background(), "http://definitely-not-a-real-domain-12345.com", lookupOptions{clientTimeout: 1000})
 	if err == nil {
 		t.Error("Expected error for invalid domain")
 	}
 
-	_, err = getExternalIPFrom("invalid-url-format", 1000)
+	_, err = getExternalIPFrom(context.Background(), "invalid-url-format", lookupOptions{clientTimeout: 1000})
 	if err == nil {
 		t.Error("Expected error for invalid URL format")
 	}
@@ -1097,7 +964,7 @@ func testIPValidation(t *testing.T) {
 			"validate_ip":    tc.validateIP,
 		})
 
-		err := dataSourceRead(d, nil)
+		err := dataSourceRead(context.Background(), d, nil)
 		if tc.shouldErr && err == nil {
 			t.Errorf("Expected error for response %q with validation %v", tc.response, tc.validateIP)
 		}
@@ -1121,13 +988,350 @@ func testTimeoutHandling(t *testing.T) {
 	defer server.Close()
 
 	for _, timeout := range timeouts {
-		ip, err := getExternalIPFrom(server.URL, timeout)
+		result, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: timeout})
 		if err != nil {
 			t.Errorf("Unexpected error with timeout %d: %v", timeout, err)
 		}
 
-		if ip != "10.0.0.1" {
+		if result.ip != "10.0.0.1" {
 			t.Errorf("Expected correct IP with timeout %d", timeout)
 		}
 	}
 }
+
+func TestGetExternalIPFromMaxResponseBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(strings.Repeat("A", 100)))
+	}))
+	defer server.Close()
+
+	_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000, maxResponseBytes: 10})
+	if err == nil {
+		t.Fatal("Expected error for oversized response")
+	}
+
+	expectedError := "response body exceeds max_response_bytes (10 bytes)"
+	if err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got: %v", expectedError, err)
+	}
+
+	// A limit equal to the body size is still accepted
+	result, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000, maxResponseBytes: 100})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(result.ip) != 100 {
+		t.Errorf("Expected 100 bytes, got: %d", len(result.ip))
+	}
+}
+
+func TestGetExternalIPFromExpectedContentTypes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		expected []string
+		wantErr  bool
+	}{
+		{"no restriction", nil, false},
+		{"exact match", []string{"text/plain"}, false},
+		{"case insensitive", []string{"Text/Plain"}, false},
+		{"wildcard subtype", []string{"text/*"}, false},
+		{"one of several", []string{"application/json", "text/plain"}, false},
+		{"mismatch", []string{"application/json"}, true},
+		{"wildcard mismatch", []string{"application/*"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{
+				clientTimeout:        1000,
+				expectedContentTypes: tt.expected,
+			})
+
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("Expected content type error")
+				}
+				if !strings.Contains(err.Error(), `unexpected response content type "text/plain"`) {
+					t.Errorf("Unexpected error message: %v", err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if result.ip != testIP {
+				t.Errorf("Expected IP %s, got: %s", testIP, result.ip)
+			}
+		})
+	}
+}
+
+func TestDataSourceReadTruncatesInvalidIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(strings.Repeat("x", 500)))
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":       server.URL,
+		"client_timeout": 1000,
+		"validate_ip":    true,
+	})
+
+	err := dataSourceRead(context.Background(), d, nil)
+	if err == nil {
+		t.Fatal("Expected error for invalid IP with validation enabled")
+	}
+
+	if !strings.Contains(err.Error(), "(436 more characters truncated)") {
+		t.Errorf("Expected truncated response in error, got: %v", err)
+	}
+
+	if strings.Contains(err.Error(), strings.Repeat("x", maxDiagnosticEcho+1)) {
+		t.Error("Expected response echo to be truncated")
+	}
+}
+
+func TestDataSourceReadMaxResponseBytes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte("203.0.113.10"))
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":           server.URL,
+		"max_response_bytes": 4,
+	})
+
+	err := dataSourceRead(context.Background(), d, nil)
+	if err == nil {
+		t.Fatal("Expected error for response larger than max_response_bytes")
+	}
+
+	expectedError := "error requesting external IP: response body exceeds max_response_bytes (4 bytes)"
+	if err.Error() != expectedError {
+		t.Errorf("Expected error '%s', got: %v", expectedError, err)
+	}
+}
+
+func TestGetExternalIPFromAcceptedStatusCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNonAuthoritativeInfo)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 1000})
+	if err == nil || err.Error() != "HTTP request error. Response code: 203" {
+		t.Errorf("Expected 203 to be rejected by default, got: %v", err)
+	}
+
+	result, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{
+		clientTimeout:       1000,
+		acceptedStatusCodes: []int{200, 203},
+	})
+	if err != nil {
+		t.Fatalf("Expected 203 to be accepted, got: %v", err)
+	}
+
+	if result.ip != testIP {
+		t.Errorf("Expected IP %s, got: %s", testIP, result.ip)
+	}
+
+	_, err = getExternalIPFrom(context.Background(), server.URL, lookupOptions{
+		clientTimeout:       1000,
+		acceptedStatusCodes: []int{204},
+	})
+	if err == nil {
+		t.Error("Expected 203 to be rejected when only 204 is accepted")
+	}
+}
+
+func TestGetExternalIPFromRedirectPolicy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer target.Close()
+
+	// Redirect to "localhost" so the target is a different host than 127.0.0.1
+	crossHostURL := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+
+	var redirector *httptest.Server
+	redirector = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/cross":
+			http.Redirect(w, r, crossHostURL, http.StatusFound)
+		case "/same":
+			http.Redirect(w, r, redirector.URL+"/final", http.StatusFound)
+		case "/loop":
+			http.Redirect(w, r, redirector.URL+"/loop", http.StatusFound)
+		case "/final":
+			_, _ = w.Write([]byte(testIP))
+		}
+	}))
+	defer redirector.Close()
+
+	tests := []struct {
+		name       string
+		path       string
+		opts       lookupOptions
+		errorRegex string
+	}{
+		{"cross host allowed", "/cross", lookupOptions{maxRedirects: 10, allowCrossHostRedirects: true}, ""},
+		{"cross host denied", "/cross", lookupOptions{maxRedirects: 10}, `redirect from host "127.0.0.1" to "localhost" not allowed`},
+		{"same host with cross host denied", "/same", lookupOptions{maxRedirects: 10}, ""},
+		{"redirects disabled", "/same", lookupOptions{maxRedirects: 0}, `stopped after 0 redirects \(max_redirects\)`},
+		{"redirect loop", "/loop", lookupOptions{maxRedirects: 3}, `stopped after 3 redirects \(max_redirects\)`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.opts.clientTimeout = 1000
+			result, err := getExternalIPFrom(context.Background(), redirector.URL+tt.path, tt.opts)
+
+			if tt.errorRegex != "" {
+				if err == nil || !regexp.MustCompile(tt.errorRegex).MatchString(err.Error()) {
+					t.Errorf("Expected error matching %q, got: %v", tt.errorRegex, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if result.ip != testIP {
+				t.Errorf("Expected IP %s, got: %s", testIP, result.ip)
+			}
+		})
+	}
+}
+
+func TestDataSourceReadRedirectSettings(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":                   "https://checkip.amazonaws.com/",
+		"accepted_status_codes":      []interface{}{200, 203},
+		"max_redirects":              2,
+		"allow_cross_host_redirects": false,
+	})
+
+	opts, err := expandLookupOptions(d)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if len(opts.acceptedStatusCodes) != 2 || opts.acceptedStatusCodes[1] != 203 {
+		t.Errorf("Expected accepted status codes [200 203], got: %v", opts.acceptedStatusCodes)
+	}
+
+	if opts.maxRedirects != 2 {
+		t.Errorf("Expected max_redirects 2, got: %d", opts.maxRedirects)
+	}
+
+	if opts.allowCrossHostRedirects {
+		t.Error("Expected allow_cross_host_redirects to be false")
+	}
+
+	defaults, err := expandLookupOptions(schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{}))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if defaults.maxRedirects != defaultMaxRedirects || !defaults.allowCrossHostRedirects {
+		t.Errorf("Expected default redirect policy, got: %+v", defaults)
+	}
+}
+
+func TestDataSourceReadContextSoftFail(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		config   map[string]interface{}
+		severity diag.Severity
+		ip       string
+	}{
+		{"fails by default", map[string]interface{}{}, diag.Error, ""},
+		{"fallback address", map[string]interface{}{"fail_on_error": false, "fallback_ipaddress": "192.0.2.1"}, diag.Warning, "192.0.2.1"},
+		{"empty fallback", map[string]interface{}{"fail_on_error": false}, diag.Warning, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config["resolver"] = server.URL
+			d := schema.TestResourceDataRaw(t, dataSource().Schema, tt.config)
+
+			diags := dataSourceReadContext(context.Background(), d, nil)
+			if len(diags) != 1 || diags[0].Severity != tt.severity {
+				t.Fatalf("Expected one diagnostic with severity %v, got: %v", tt.severity, diags)
+			}
+
+			if tt.severity == diag.Error {
+				if d.Id() != "" {
+					t.Error("Expected no ID on a hard failure")
+				}
+				return
+			}
+
+			if !strings.Contains(diags[0].Summary, "using fallback address") {
+				t.Errorf("Expected fallback warning, got: %q", diags[0].Summary)
+			}
+
+			if d.Get("ipaddress").(string) != tt.ip {
+				t.Errorf("Expected ipaddress %q, got: %q", tt.ip, d.Get("ipaddress"))
+			}
+
+			if d.Get("succeeded").(bool) {
+				t.Error("Expected succeeded to be false")
+			}
+
+			if d.Get("source") != sourceFallback {
+				t.Errorf("Expected source %s, got: %v", sourceFallback, d.Get("source"))
+			}
+
+			if d.Id() == "" {
+				t.Error("Expected ID to be set")
+			}
+		})
+	}
+}
+
+func TestDataSourceReadSetsSucceeded(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":           server.URL,
+		"fail_on_error":      false,
+		"fallback_ipaddress": "192.0.2.1",
+	})
+
+	if diags := dataSourceReadContext(context.Background(), d, nil); len(diags) != 0 {
+		t.Fatalf("Expected no diagnostics, got: %v", diags)
+	}
+
+	if !d.Get("succeeded").(bool) {
+		t.Error("Expected succeeded to be true")
+	}
+
+	if d.Get("ipaddress").(string) != testIP {
+		t.Errorf("Expected resolved address, got: %q", d.Get("ipaddress"))
+	}
+}
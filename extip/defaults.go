@@ -0,0 +1,162 @@
+package extip
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// Environment variables that supply provider arguments left out of the
+// provider block. Arguments set in the configuration take precedence.
+const (
+	envResolver      = "EXTIP_RESOLVER"
+	envResolvers     = "EXTIP_RESOLVERS"
+	envClientTimeout = "EXTIP_CLIENT_TIMEOUT"
+	envProxy         = "EXTIP_PROXY"
+	envValidateIP    = "EXTIP_VALIDATE_IP"
+	envRequireHTTPS  = "EXTIP_REQUIRE_HTTPS"
+
+	envOverrideIPAddress = "EXTIP_OVERRIDE_IPADDRESS"
+	envRecordFile        = "EXTIP_RECORD_FILE"
+	envReplayFile        = "EXTIP_REPLAY_FILE"
+)
+
+// lookupDefaults are provider-wide lookup settings. They apply to every data
+// source that does not set the matching attribute itself, so the precedence is:
+// data source attribute, provider argument, EXTIP_* environment variable,
+// built-in default.
+type lookupDefaults struct {
+	// resolver replaces the built-in default resolver, empty keeps it.
+	resolver string
+	// resolvers are tried in order after resolver fails.
+	resolvers []string
+	// clientTimeout is the request timeout in milliseconds, 0 keeps the data source value.
+	clientTimeout int
+	// proxy is the URL of the proxy HTTP lookups go through, empty connects directly.
+	proxy string
+	// validateIP turns on response validation for data sources that leave it unset.
+	validateIP bool
+}
+
+// expandLookupDefaults builds the provider-wide lookup settings. Environment
+// variables reach the list-valued resolvers argument here, because schema
+// defaults are not available for lists.
+func expandLookupDefaults(d *schema.ResourceData) (lookupDefaults, error) {
+	resolver, ok := d.Get("resolver").(string)
+	if !ok {
+		return lookupDefaults{}, errors.New("resolver is not a string")
+	}
+
+	resolvers, ok := d.Get("resolvers").([]interface{})
+	if !ok {
+		return lookupDefaults{}, errors.New("resolvers is not a list")
+	}
+
+	clientTimeout, ok := d.Get("client_timeout").(int)
+	if !ok {
+		return lookupDefaults{}, errors.New("client_timeout is not an int")
+	}
+
+	proxy, ok := d.Get("proxy").(string)
+	if !ok {
+		return lookupDefaults{}, errors.New("proxy is not a string")
+	}
+
+	validateIP, ok := d.Get("validate_ip").(bool)
+	if !ok {
+		return lookupDefaults{}, errors.New("validate_ip is not a bool")
+	}
+
+	defaults := lookupDefaults{
+		resolver:      resolver,
+		resolvers:     expandStringList(resolvers),
+		clientTimeout: clientTimeout,
+		proxy:         proxy,
+		validateIP:    validateIP,
+	}
+
+	if len(defaults.resolvers) == 0 {
+		fromEnv, err := resolversFromEnv()
+		if err != nil {
+			return lookupDefaults{}, err
+		}
+		defaults.resolvers = fromEnv
+	}
+
+	return defaults, nil
+}
+
+// resolversFromEnv parses the comma-separated EXTIP_RESOLVERS list.
+func resolversFromEnv() ([]string, error) {
+	var resolvers []string
+	for _, resolver := range strings.Split(os.Getenv(envResolvers), ",") {
+		resolver = strings.TrimSpace(resolver)
+		if resolver == "" {
+			continue
+		}
+		if _, errs := validateResolver(resolver, envResolvers); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid %s: %w", envResolvers, errs[0])
+		}
+		resolvers = append(resolvers, resolver)
+	}
+	return resolvers, nil
+}
+
+// resolverCandidates returns the resolvers to try in order for a data source.
+// A resolver set on the data source is the only candidate, otherwise the
+// provider resolver and resolvers are used before the built-in default.
+func (l lookupDefaults) resolverCandidates(d *schema.ResourceData) ([]string, error) {
+	resolver, ok := d.Get("resolver").(string)
+	if !ok {
+		return nil, errors.New("resolver is not a string")
+	}
+
+	if isConfigured(d, "resolver", defaultResolver) {
+		return []string{resolver}, nil
+	}
+
+	var candidates []string
+	if l.resolver != "" {
+		candidates = append(candidates, l.resolver)
+	}
+	candidates = append(candidates, l.resolvers...)
+
+	if len(candidates) == 0 {
+		return []string{resolver}, nil
+	}
+	return candidates, nil
+}
+
+// apply fills in the provider-wide settings the data source leaves unset.
+func (l lookupDefaults) apply(d *schema.ResourceData, opts *lookupOptions) {
+	if l.clientTimeout > 0 && !isConfigured(d, "client_timeout", defaultClientTimeout) {
+		opts.clientTimeout = l.clientTimeout
+	}
+	opts.proxy = l.proxy
+}
+
+// shouldValidateIP reports whether the response must be a valid IP address.
+func (l lookupDefaults) shouldValidateIP(d *schema.ResourceData) bool {
+	if isConfigured(d, "validate_ip", false) {
+		validateIP, ok := d.Get("validate_ip").(bool)
+		return ok && validateIP
+	}
+	return l.validateIP
+}
+
+// isConfigured reports whether key was set in the data source configuration
+// rather than left to its schema default. Terraform always sends the raw
+// configuration; without it, as in unit tests, a value equal to the schema
+// default counts as unset.
+func isConfigured(d *schema.ResourceData, key string, schemaDefault interface{}) bool {
+	raw := d.GetRawConfig()
+	if raw.IsKnown() && !raw.IsNull() && raw.Type().IsObjectType() && raw.Type().HasAttribute(key) {
+		return !raw.GetAttr(key).IsNull()
+	}
+
+	value, ok := d.GetOk(key)
+	return ok && value != schemaDefault
+}
@@ -0,0 +1,214 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func configureTestProvider(t *testing.T, raw map[string]interface{}) *providerConfig {
+	t.Helper()
+
+	meta, diags := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, raw))
+	if diags.HasError() {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+	return meta.(*providerConfig)
+}
+
+func TestProviderDefaultsFromEnvironment(t *testing.T) {
+	t.Setenv(envResolver, "ipify")
+	t.Setenv(envResolvers, "icanhazip, https://example.com/ip")
+	t.Setenv(envClientTimeout, "2500")
+	t.Setenv(envProxy, "http://proxy.example:3128")
+	t.Setenv(envValidateIP, "true")
+	t.Setenv(envRequireHTTPS, "true")
+
+	config := configureTestProvider(t, map[string]interface{}{})
+
+	expected := lookupDefaults{
+		resolver:      "ipify",
+		resolvers:     []string{"icanhazip", "https://example.com/ip"},
+		clientTimeout: 2500,
+		proxy:         "http://proxy.example:3128",
+		validateIP:    true,
+	}
+	if !reflect.DeepEqual(config.defaults, expected) {
+		t.Errorf("Expected defaults %+v, got %+v", expected, config.defaults)
+	}
+
+	if !config.policy.requireHTTPS {
+		t.Error("Expected require_https from the environment")
+	}
+}
+
+func TestProviderArgumentsOverrideEnvironment(t *testing.T) {
+	t.Setenv(envResolver, "ipify")
+	t.Setenv(envResolvers, "icanhazip")
+	t.Setenv(envClientTimeout, "2500")
+
+	config := configureTestProvider(t, map[string]interface{}{
+		"resolver":       "aws",
+		"resolvers":      []interface{}{"cloudflare"},
+		"client_timeout": 500,
+	})
+
+	if config.defaults.resolver != "aws" {
+		t.Errorf("Expected provider resolver to win, got %q", config.defaults.resolver)
+	}
+	if !reflect.DeepEqual(config.defaults.resolvers, []string{"cloudflare"}) {
+		t.Errorf("Expected provider resolvers to win, got %v", config.defaults.resolvers)
+	}
+	if config.defaults.clientTimeout != 500 {
+		t.Errorf("Expected provider client_timeout to win, got %d", config.defaults.clientTimeout)
+	}
+}
+
+func TestProviderDefaultsInvalidEnvironment(t *testing.T) {
+	t.Setenv(envResolvers, "aws,not a resolver")
+
+	_, diags := providerConfigure(context.Background(), schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{}))
+	if !diags.HasError() {
+		t.Error("Expected an invalid EXTIP_RESOLVERS entry to be rejected")
+	}
+}
+
+func TestLookupDefaultsResolverCandidates(t *testing.T) {
+	defaults := lookupDefaults{resolver: "aws", resolvers: []string{"ipify", "icanhazip"}}
+
+	unset := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{})
+	candidates, err := defaults.resolverCandidates(unset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(candidates, []string{"aws", "ipify", "icanhazip"}) {
+		t.Errorf("Expected provider resolvers, got %v", candidates)
+	}
+
+	set := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "cloudflare"})
+	candidates, err = defaults.resolverCandidates(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(candidates, []string{"cloudflare"}) {
+		t.Errorf("Expected only the data source resolver, got %v", candidates)
+	}
+
+	candidates, err = lookupDefaults{}.resolverCandidates(unset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(candidates, []string{defaultResolver}) {
+		t.Errorf("Expected the built-in default, got %v", candidates)
+	}
+}
+
+func TestLookupDefaultsApply(t *testing.T) {
+	defaults := lookupDefaults{clientTimeout: 2500, proxy: "http://proxy.example:3128"}
+
+	opts := lookupOptions{clientTimeout: defaultClientTimeout}
+	defaults.apply(schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{}), &opts)
+	if opts.clientTimeout != 2500 || opts.proxy != "http://proxy.example:3128" {
+		t.Errorf("Expected provider defaults to apply, got %+v", opts)
+	}
+
+	opts = lookupOptions{clientTimeout: 300}
+	defaults.apply(schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"client_timeout": 300}), &opts)
+	if opts.clientTimeout != 300 {
+		t.Errorf("Expected data source client_timeout to win, got %d", opts.clientTimeout)
+	}
+}
+
+func TestDataSourceReadFallsBackToNextResolver(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer working.Close()
+
+	meta := &providerConfig{
+		transports: fallbackTransports,
+		defaults:   lookupDefaults{resolver: failing.URL, resolvers: []string{working.URL}},
+	}
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{})
+	if err := dataSourceRead(context.Background(), d, meta); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if d.Get("ipaddress") != testIP {
+		t.Errorf("Expected %s, got %v", testIP, d.Get("ipaddress"))
+	}
+	if d.Get("resolver_used") != working.URL {
+		t.Errorf("Expected resolver_used %s, got %v", working.URL, d.Get("resolver_used"))
+	}
+	if d.Get("attempts") != 2 {
+		t.Errorf("Expected 2 attempts, got %v", d.Get("attempts"))
+	}
+	if d.Get("source") != sourceResolver {
+		t.Errorf("Expected source %s, got %v", sourceResolver, d.Get("source"))
+	}
+}
+
+func TestDataSourceReadAllResolversFail(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	meta := &providerConfig{
+		transports: fallbackTransports,
+		defaults:   lookupDefaults{resolvers: []string{failing.URL, failing.URL}},
+	}
+
+	err := dataSourceRead(context.Background(), schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{}), meta)
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected the last resolver's status error, got: %v", err)
+	}
+}
+
+func TestDataSourceReadProviderValidateIP(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("not an ip"))
+	}))
+	defer server.Close()
+
+	meta := &providerConfig{transports: fallbackTransports, defaults: lookupDefaults{validateIP: true}}
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": server.URL})
+	err := dataSourceRead(context.Background(), d, meta)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("Expected provider validate_ip to apply, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromProxy(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "resolver.invalid" {
+			t.Errorf("Expected a proxied request for resolver.invalid, got %s", r.URL)
+		}
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer proxy.Close()
+
+	result, err := getExternalIPFrom(context.Background(), "http://resolver.invalid/", lookupOptions{proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.ip != testIP {
+		t.Errorf("Expected %s, got %s", testIP, result.ip)
+	}
+}
@@ -0,0 +1,137 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// getExternalIPFromDNS asks a DNS server for a record that holds the caller's
+// address, such as OpenDNS' myip.opendns.com.
+func getExternalIPFromDNS(ctx context.Context, entry resolverEntry, opts lookupOptions) (result *lookupResult, err error) {
+	result = &lookupResult{resolverUsed: entry.target(), attempts: 1}
+	start := time.Now()
+
+	logDebug(ctx, "Querying resolver", map[string]interface{}{
+		logFieldResolver: result.resolverUsed,
+		logFieldStrategy: protocolDNS,
+		logFieldAttempt:  1,
+	})
+
+	defer func() {
+		result.latency = time.Since(start)
+		logLookupOutcome(ctx, result, err)
+	}()
+
+	if opts.clientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msToDuration(opts.clientTimeout))
+		defer cancel()
+	}
+
+	opts.network = networkForFamily(entry.addressFamily)
+	opts, err = opts.bindSource()
+	if err != nil {
+		return result, err
+	}
+
+	var answer string
+	if opts.mocks != nil {
+		answer, err = opts.mocks.lookupDNS(ctx, entry)
+	} else {
+		answer, err = queryDNS(ctx, entry, opts, result)
+	}
+	if opts.recorder != nil {
+		opts.recorder.recordAnswer(ctx, protocolDNS, entry.name, answer, err)
+	}
+	if err != nil {
+		return result, classifyDNSError(result.resolverUsed, err)
+	}
+
+	result.ip = answer
+	return result, nil
+}
+
+// queryDNS asks the DNS server of entry for the caller's address, recording
+// the server address in result.
+func queryDNS(ctx context.Context, entry resolverEntry, opts lookupOptions, result *lookupResult) (string, error) {
+	var mu sync.Mutex
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			if opts.tunnel != nil {
+				// Over SSH, queries use TCP whatever network the resolver asks for
+				return opts.tunnel.dialContext("tcp")(ctx, network, entry.dnsServer)
+			}
+			// The resolver asks for udp, or tcp for truncated answers; either
+			// keeps to the address family of the entry
+			if opts.network != "" {
+				network = strings.Replace(opts.network, "tcp", network, 1)
+			}
+			dialer := &net.Dialer{
+				Timeout:   msToDuration(opts.dialTimeout),
+				LocalAddr: localAddr(network, opts.sourceAddress),
+			}
+			conn, err := dialer.DialContext(ctx, network, entry.dnsServer)
+			if err == nil {
+				mu.Lock()
+				result.remoteAddr = hostFromAddr(conn.RemoteAddr())
+				result.localAddr = hostFromAddr(conn.LocalAddr())
+				mu.Unlock()
+			}
+			return conn, err
+		},
+	}
+
+	if entry.dnsRecord == "TXT" {
+		return lookupTXTAddress(ctx, resolver, entry.dnsName)
+	}
+	return lookupIPAddress(ctx, resolver, entry.dnsName, entry.dnsRecord)
+}
+
+func lookupIPAddress(ctx context.Context, resolver *net.Resolver, name, record string) (string, error) {
+	network := "ip4"
+	if record == "AAAA" {
+		network = "ip6"
+	}
+
+	ips, err := resolver.LookupIP(ctx, network, name)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("no %s record for %s", record, name)
+	}
+	return ips[0].String(), nil
+}
+
+func lookupTXTAddress(ctx context.Context, resolver *net.Resolver, name string) (string, error) {
+	records, err := resolver.LookupTXT(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	for _, record := range records {
+		if ip := net.ParseIP(strings.TrimSpace(record)); ip != nil {
+			return ip.String(), nil
+		}
+	}
+	return "", fmt.Errorf("no TXT record for %s holds an IP address", name)
+}
+
+// classifyDNSError wraps a DNS query failure in the matching error type.
+func classifyDNSError(resolver string, err error) error {
+	if isTimeout(err) {
+		return &TimeoutError{Resolver: resolver, Phase: "DNS query", Setting: "client_timeout", Err: err}
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return &DNSError{Resolver: resolver, Err: err}
+	}
+	return &ResponseError{Resolver: resolver, Setting: "resolver", Reason: err.Error()}
+}
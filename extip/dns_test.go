@@ -0,0 +1,163 @@
+package extip
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+// startDNSStandIn answers every A and TXT query on a local UDP socket with
+// the given address. It understands just enough DNS for the Go resolver.
+func startDNSStandIn(t *testing.T, answer net.IP) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go serveDNS(conn, answer)
+	return conn.LocalAddr().String()
+}
+
+func serveDNS(conn net.PacketConn, answer net.IP) {
+	buf := make([]byte, 512)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if reply := dnsReply(buf[:n], answer); reply != nil {
+			_, _ = conn.WriteTo(reply, addr)
+		}
+	}
+}
+
+func dnsReply(query []byte, answer net.IP) []byte {
+	if len(query) < 12 {
+		return nil
+	}
+
+	// The question section ends with the name's root label, then type and class
+	end := 12
+	for end < len(query) && query[end] != 0 {
+		end += int(query[end]) + 1
+	}
+	end += 5
+	if end > len(query) {
+		return nil
+	}
+	qtype := binary.BigEndian.Uint16(query[end-4 : end-2])
+
+	var rdata []byte
+	switch qtype {
+	case 1: // A
+		rdata = answer.To4()
+	case 16: // TXT
+		text := answer.String()
+		rdata = append([]byte{byte(len(text))}, text...)
+	}
+
+	reply := append([]byte{}, query[:end]...)
+	reply[2] = 0x84 // response, authoritative
+	reply[3] = 0x00
+	binary.BigEndian.PutUint16(reply[4:], 1)  // questions
+	binary.BigEndian.PutUint16(reply[6:], 0)  // answers
+	binary.BigEndian.PutUint16(reply[8:], 0)  // authority
+	binary.BigEndian.PutUint16(reply[10:], 0) // additional
+	if rdata == nil {
+		return reply
+	}
+
+	binary.BigEndian.PutUint16(reply[6:], 1)
+	record := []byte{0xc0, 0x0c} // pointer to the question name
+	record = binary.BigEndian.AppendUint16(record, qtype)
+	record = binary.BigEndian.AppendUint16(record, 1)  // class IN
+	record = binary.BigEndian.AppendUint32(record, 60) // TTL
+	record = binary.BigEndian.AppendUint16(record, uint16(len(rdata)))
+	record = append(record, rdata...)
+	return append(reply, record...)
+}
+
+func TestGetExternalIPFromDNS(t *testing.T) {
+	server := startDNSStandIn(t, net.ParseIP("198.51.100.4"))
+
+	tests := []struct {
+		name   string
+		record string
+	}{
+		{"A record", "A"},
+		{"TXT record", "TXT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := resolverEntry{
+				protocol:  protocolDNS,
+				dnsServer: server,
+				dnsName:   "myip.example.test",
+				dnsRecord: tt.record,
+			}
+
+			result, err := getExternalIPFromDNS(context.Background(), entry, lookupOptions{clientTimeout: 1000})
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if result.ip != "198.51.100.4" {
+				t.Errorf("Expected 198.51.100.4, got: %s", result.ip)
+			}
+
+			if result.remoteAddr != testIP {
+				t.Errorf("Expected remote address %s, got: %s", testIP, result.remoteAddr)
+			}
+
+			if result.attempts != 1 {
+				t.Errorf("Expected 1 attempt, got: %d", result.attempts)
+			}
+		})
+	}
+}
+
+func TestGetExternalIPFromDNSNoAnswer(t *testing.T) {
+	server := startDNSStandIn(t, nil)
+
+	entry := resolverEntry{protocol: protocolDNS, dnsServer: server, dnsName: "myip.example.test", dnsRecord: "A"}
+	_, err := getExternalIPFromDNS(context.Background(), entry, lookupOptions{clientTimeout: 1000})
+	if err == nil {
+		t.Fatal("Expected error when the server has no answer")
+	}
+}
+
+func TestGetExternalIPFromDNSAddressFamily(t *testing.T) {
+	server := startDNSStandIn(t, net.ParseIP("198.51.100.5"))
+
+	entry := resolverEntry{protocol: protocolDNS, dnsServer: server, dnsName: "myip.example.test", dnsRecord: "A", addressFamily: familyIPv4}
+	result, err := getExternalIPFromDNS(context.Background(), entry, lookupOptions{clientTimeout: 1000})
+	if err != nil {
+		t.Fatalf("Expected an IPv4 entry to reach an IPv4 server, got: %v", err)
+	}
+	if result.localAddr != testIP {
+		t.Errorf("Expected an IPv4 local address, got: %s", result.localAddr)
+	}
+
+	// udp6 cannot reach an IPv4 server, where plain udp would
+	entry.addressFamily = familyIPv6
+	if _, err := getExternalIPFromDNS(context.Background(), entry, lookupOptions{clientTimeout: 1000}); err == nil {
+		t.Error("Expected an IPv6 entry to dial an IPv6 network")
+	}
+
+	conn, err := net.ListenPacket("udp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 loopback is not available: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	go serveDNS(conn, net.ParseIP("198.51.100.5"))
+
+	entry = resolverEntry{protocol: protocolDNS, dnsServer: conn.LocalAddr().String(), dnsName: "myip.example.test", dnsRecord: "A", addressFamily: familyIPv4}
+	if _, err := getExternalIPFromDNS(context.Background(), entry, lookupOptions{clientTimeout: 1000}); err == nil {
+		t.Error("Expected an IPv4 entry to dial an IPv4 network")
+	}
+}
@@ -0,0 +1,282 @@
+package extip
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+)
+
+// DNSError is returned when the resolver host name could not be resolved.
+type DNSError struct {
+	Resolver string
+	Err      error
+}
+
+func (e *DNSError) Error() string { return e.Err.Error() }
+func (e *DNSError) Unwrap() error { return e.Err }
+
+// TimeoutError is returned when a lookup ran out of time. Phase names the
+// part of the request that was in progress and Setting the argument bounding it.
+type TimeoutError struct {
+	Resolver string
+	Phase    string
+	Setting  string
+	Err      error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Setting != "" {
+		return fmt.Sprintf("timed out %s (see %s): %s", phaseClause(e.Phase), e.Setting, e.Err)
+	}
+	return fmt.Sprintf("timed out %s: %s", phaseClause(e.Phase), e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+// TLSError is returned when the TLS handshake with the resolver failed.
+type TLSError struct {
+	Resolver string
+	Err      error
+}
+
+func (e *TLSError) Error() string { return e.Err.Error() }
+func (e *TLSError) Unwrap() error { return e.Err }
+
+// ConnectionError is returned for any other failure to talk to the resolver,
+// such as a refused or dropped connection.
+type ConnectionError struct {
+	Resolver string
+	Err      error
+}
+
+func (e *ConnectionError) Error() string { return e.Err.Error() }
+func (e *ConnectionError) Unwrap() error { return e.Err }
+
+// RedirectError is returned when a redirect is refused by the redirect policy.
+// Setting names the argument that refused it.
+type RedirectError struct {
+	Setting string
+	Reason  string
+}
+
+func (e *RedirectError) Error() string { return e.Reason }
+
+// SourceError is returned when a lookup cannot be bound to the requested local
+// address or interface. Setting names the argument at fault.
+type SourceError struct {
+	Setting string
+	Reason  string
+}
+
+func (e *SourceError) Error() string { return e.Reason }
+
+// SSHError is returned when the ssh block cannot connect or log in to the
+// remote host, or cannot run a command on it.
+type SSHError struct {
+	Host string
+	Err  error
+}
+
+func (e *SSHError) Error() string { return fmt.Sprintf("%s: %s", e.Host, e.Err) }
+func (e *SSHError) Unwrap() error { return e.Err }
+
+// StatusError is returned when the resolver answers with a status code that
+// is not accepted.
+type StatusError struct {
+	Resolver   string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("HTTP request error. Response code: %d", e.StatusCode)
+}
+
+// ResponseError is returned when the resolver response is rejected before it
+// is parsed, for example because it is too large. Setting names the argument
+// that rejected it.
+type ResponseError struct {
+	Resolver string
+	Setting  string
+	Reason   string
+}
+
+func (e *ResponseError) Error() string { return e.Reason }
+
+// ValidationError is returned when validate_ip is set and the resolver
+// response is not an IP address. Value is already truncated for display.
+type ValidationError struct {
+	Resolver string
+	Value    string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("validate_ip was set to true, and information from resolver was not valid IP: %s", e.Value)
+}
+
+// classifyTransportError wraps an error from the HTTP client in the matching
+// error type. Errors that are already classified are returned unchanged.
+func classifyTransportError(resolver string, err error) error {
+	var (
+		timeoutErr  *TimeoutError
+		redirectErr *RedirectError
+		policyErr   *PolicyError
+		responseErr *ResponseError
+		dnsErr      *net.DNSError
+	)
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &timeoutErr):
+		timeoutErr.Resolver = resolver
+		return err
+	case errors.As(err, &redirectErr):
+		return redirectErr
+	case errors.As(err, &policyErr):
+		return policyErr
+	case errors.As(err, &responseErr):
+		responseErr.Resolver = resolver
+		return responseErr
+	case errors.As(err, &dnsErr):
+		return &DNSError{Resolver: resolver, Err: err}
+	case isTLSError(err):
+		return &TLSError{Resolver: resolver, Err: err}
+	default:
+		return &ConnectionError{Resolver: resolver, Err: err}
+	}
+}
+
+// isTLSError reports whether err came from the TLS handshake or certificate checks.
+func isTLSError(err error) bool {
+	var (
+		recordErr    tls.RecordHeaderError
+		alertErr     tls.AlertError
+		verifyErr    *tls.CertificateVerificationError
+		authorityErr x509.UnknownAuthorityError
+		hostnameErr  x509.HostnameError
+		invalidErr   x509.CertificateInvalidError
+	)
+
+	return errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &authorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &invalidErr)
+}
+
+// errorDiagnostic turns a data source error into a diagnostic with a summary
+// per failure class and, where possible, the attribute to look at.
+// isSet reports whether an argument was configured, so timeouts point at the
+// setting the user actually controls.
+func errorDiagnostic(err error, isSet func(string) bool) diag.Diagnostic {
+	if d, ok := setupDiagnostic(err); ok {
+		return d
+	}
+	if d, ok := transportDiagnostic(err, isSet); ok {
+		return d
+	}
+	if d, ok := responseDiagnostic(err); ok {
+		return d
+	}
+	return diag.Diagnostic{Severity: diag.Error, Summary: err.Error()}
+}
+
+// setupDiagnostic describes errors raised before the resolver is contacted.
+func setupDiagnostic(err error) (diag.Diagnostic, bool) {
+	var (
+		policyErr *PolicyError
+		sourceErr *SourceError
+		sshErr    *SSHError
+	)
+
+	switch {
+	case errors.As(err, &policyErr):
+		return errorDiag("Resolver blocked by provider policy", "resolver", err,
+			fmt.Sprintf("Choose a resolver permitted by the provider's %s setting, or ask whoever manages the provider configuration to allow it.",
+				policyErr.Setting)), true
+	case errors.As(err, &sourceErr):
+		return errorDiag("Cannot send lookup from the requested source", sourceErr.Setting, err,
+			"Check the local addresses of the machine running Terraform, and that the resolver supports the address family of the source."), true
+	case errors.As(err, &sshErr):
+		return errorDiag("Cannot reach the remote host over SSH", "ssh", err,
+			"Check the ssh block: the host must be reachable, accept the user and key or agent, and have its host key in known_hosts_file or host_key."), true
+	default:
+		return diag.Diagnostic{}, false
+	}
+}
+
+// transportDiagnostic describes errors reaching the resolver.
+func transportDiagnostic(err error, isSet func(string) bool) (diag.Diagnostic, bool) {
+	var (
+		dnsErr        *DNSError
+		timeoutErr    *TimeoutError
+		tlsErr        *TLSError
+		connectionErr *ConnectionError
+	)
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return errorDiag("Resolver host could not be resolved", "resolver", err,
+			"Check the resolver URL and the DNS configuration of the machine running Terraform."), true
+	case errors.As(err, &timeoutErr):
+		setting := timeoutErr.Setting
+		if setting == "" || !isSet(setting) {
+			setting = "client_timeout"
+		}
+		return errorDiag("Resolver request timed out", setting, err,
+			fmt.Sprintf("The request timed out %s. Increase %s or choose a resolver closer to the machine running Terraform.",
+				phaseClause(timeoutErr.Phase), setting)), true
+	case errors.As(err, &tlsErr):
+		return errorDiag("TLS error contacting resolver", "resolver", err,
+			"Check that the resolver presents a certificate trusted by the machine running Terraform."), true
+	case errors.As(err, &connectionErr):
+		return errorDiag("Could not connect to resolver", "resolver", err,
+			"Check that the resolver is reachable from the machine running Terraform."), true
+	default:
+		return diag.Diagnostic{}, false
+	}
+}
+
+// responseDiagnostic describes errors in what the resolver answered.
+func responseDiagnostic(err error) (diag.Diagnostic, bool) {
+	var (
+		redirectErr   *RedirectError
+		statusErr     *StatusError
+		responseErr   *ResponseError
+		validationErr *ValidationError
+	)
+
+	switch {
+	case errors.As(err, &redirectErr):
+		return errorDiag("Resolver redirect refused", redirectErr.Setting, err,
+			fmt.Sprintf("Adjust %s if this redirect is expected.", redirectErr.Setting)), true
+	case errors.As(err, &statusErr):
+		return errorDiag("Resolver returned an unexpected status", "resolver", err,
+			"Check the resolver URL, or add the status to accepted_status_codes if it is a valid answer."), true
+	case errors.As(err, &responseErr):
+		return errorDiag("Resolver response rejected", responseErr.Setting, err,
+			fmt.Sprintf("Check the resolver URL or adjust %s.", responseErr.Setting)), true
+	case errors.As(err, &validationErr):
+		return errorDiag("Resolver response is not a valid IP address", "validate_ip", err,
+			"Check that the resolver returns a plain IP address, or unset validate_ip."), true
+	default:
+		return diag.Diagnostic{}, false
+	}
+}
+
+// errorDiag builds an error diagnostic whose detail is the error itself
+// followed by a hint on how to fix it.
+func errorDiag(summary, attribute string, err error, hint string) diag.Diagnostic {
+	return diag.Diagnostic{
+		Severity:      diag.Error,
+		Summary:       summary,
+		Detail:        fmt.Sprintf("%s\n\n%s", err.Error(), hint),
+		AttributePath: cty.GetAttrPath(attribute),
+	}
+}
@@ -0,0 +1,156 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestGetExternalIPFromErrorTypes(t *testing.T) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer tlsServer.Close()
+
+	slowServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer slowServer.Close()
+
+	statusServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer statusServer.Close()
+
+	// Grab a free port and close it again so connections are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	refusedURL := "http://" + listener.Addr().String()
+	_ = listener.Close()
+
+	tests := []struct {
+		name     string
+		resolver string
+		opts     lookupOptions
+		check    func(error) bool
+	}{
+		{"dns", "http://extip-test.invalid", lookupOptions{}, func(err error) bool {
+			var target *DNSError
+			return errors.As(err, &target)
+		}},
+		{"tls", tlsServer.URL, lookupOptions{}, func(err error) bool {
+			var target *TLSError
+			return errors.As(err, &target)
+		}},
+		{"connection", refusedURL, lookupOptions{}, func(err error) bool {
+			var target *ConnectionError
+			return errors.As(err, &target)
+		}},
+		{"timeout", slowServer.URL, lookupOptions{clientTimeout: 50}, func(err error) bool {
+			var target *TimeoutError
+			return errors.As(err, &target) && target.Resolver == slowServer.URL
+		}},
+		{"status", statusServer.URL, lookupOptions{}, func(err error) bool {
+			var target *StatusError
+			return errors.As(err, &target) && target.StatusCode == http.StatusServiceUnavailable
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := getExternalIPFrom(context.Background(), tt.resolver, tt.opts)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			if !tt.check(err) {
+				t.Errorf("Unexpected error type %T: %v", err, err)
+			}
+		})
+	}
+}
+
+func TestErrorDiagnostic(t *testing.T) {
+	notSet := func(string) bool { return false }
+	allSet := func(string) bool { return true }
+
+	tests := []struct {
+		name      string
+		err       error
+		isSet     func(string) bool
+		summary   string
+		attribute string
+	}{
+		{"ssh", &SSHError{Host: "ssh://terraform@bastion:22", Err: errors.New("handshake failed")}, notSet, "Cannot reach the remote host over SSH", "ssh"},
+		{"dns", &DNSError{Err: errors.New("no such host")}, notSet, "Resolver host could not be resolved", "resolver"},
+		{"tls", &TLSError{Err: errors.New("bad certificate")}, notSet, "TLS error contacting resolver", "resolver"},
+		{"connection", &ConnectionError{Err: errors.New("refused")}, notSet, "Could not connect to resolver", "resolver"},
+		{"status", &StatusError{StatusCode: 404}, notSet, "Resolver returned an unexpected status", "resolver"},
+		{"timeout default", &TimeoutError{Phase: phaseTLS, Setting: "tls_handshake_timeout", Err: context.DeadlineExceeded}, notSet, "Resolver request timed out", "client_timeout"},
+		{"timeout configured", &TimeoutError{Phase: phaseTLS, Setting: "tls_handshake_timeout", Err: context.DeadlineExceeded}, allSet, "Resolver request timed out", "tls_handshake_timeout"},
+		{"redirect", &RedirectError{Setting: "max_redirects", Reason: "stopped"}, notSet, "Resolver redirect refused", "max_redirects"},
+		{"response", &ResponseError{Setting: "max_response_bytes", Reason: "too big"}, notSet, "Resolver response rejected", "max_response_bytes"},
+		{"validation", &ValidationError{Value: "HELLO!"}, notSet, "Resolver response is not a valid IP address", "validate_ip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Wrapping must not hide the error class
+			d := errorDiagnostic(errors.Join(errors.New("error requesting external IP"), tt.err), tt.isSet)
+
+			if d.Severity != diag.Error {
+				t.Errorf("Expected error severity, got: %v", d.Severity)
+			}
+			if d.Summary != tt.summary {
+				t.Errorf("Expected summary %q, got: %q", tt.summary, d.Summary)
+			}
+			if !d.AttributePath.Equals(cty.GetAttrPath(tt.attribute)) {
+				t.Errorf("Expected attribute path %q, got: %#v", tt.attribute, d.AttributePath)
+			}
+			if !strings.Contains(d.Detail, tt.err.Error()) {
+				t.Errorf("Expected detail to contain the error, got: %q", d.Detail)
+			}
+		})
+	}
+
+	unknown := errorDiagnostic(errors.New("something else"), notSet)
+	if unknown.Summary != "something else" || unknown.AttributePath != nil {
+		t.Errorf("Unexpected diagnostic for unclassified error: %#v", unknown)
+	}
+}
+
+func TestDataSourceReadContextDiagnostics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("HELLO!"))
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":    server.URL,
+		"validate_ip": true,
+	})
+
+	diags := dataSourceReadContext(context.Background(), d, nil)
+	if len(diags) != 1 {
+		t.Fatalf("Expected one diagnostic, got: %v", diags)
+	}
+
+	if !diags[0].AttributePath.Equals(cty.GetAttrPath("validate_ip")) {
+		t.Errorf("Expected validate_ip attribute path, got: %#v", diags[0].AttributePath)
+	}
+
+	if !strings.Contains(diags[0].Detail, "not valid IP: HELLO!") {
+		t.Errorf("Expected detail to contain the response, got: %q", diags[0].Detail)
+	}
+}
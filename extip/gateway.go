@@ -0,0 +1,415 @@
+package extip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Gateway protocols, used as resolver URL schemes.
+const (
+	protocolNATPMP = "natpmp"
+	protocolPCP    = "pcp"
+)
+
+const (
+	// gatewayPort is the port NAT-PMP and PCP servers listen on.
+	gatewayPort = 5351
+
+	// gatewayInitialWait is the first retransmission interval. It doubles
+	// after each attempt, as both RFC 6886 and RFC 6887 require.
+	gatewayInitialWait = 250 * time.Millisecond
+
+	// gatewayMaxAttempts bounds the retransmissions, as RFC 6886 recommends.
+	gatewayMaxAttempts = 9
+
+	// pcpMapLifetime is the lifetime in seconds of the mapping PCP needs to
+	// report the external address. The mapping is deleted right after.
+	pcpMapLifetime = 60
+
+	pcpVersion = 2
+	pcpOpMap   = 1
+)
+
+// natpmpResults names the NAT-PMP result codes of RFC 6886.
+var natpmpResults = map[uint16]string{
+	1: "unsupported version",
+	2: "not authorized or refused",
+	3: "network failure",
+	4: "out of resources",
+	5: "unsupported opcode",
+}
+
+// pcpResults names the PCP result codes of RFC 6887.
+var pcpResults = map[byte]string{
+	1:  "unsupported version, try natpmp:// instead",
+	2:  "not authorized",
+	3:  "malformed request",
+	4:  "unsupported opcode",
+	5:  "unsupported option",
+	6:  "malformed option",
+	7:  "network failure",
+	8:  "no resources",
+	9:  "unsupported protocol",
+	10: "user exceeded quota",
+	11: "cannot provide external address",
+	12: "address mismatch",
+	13: "excessive remote peers",
+}
+
+// gatewayTarget is a NAT-PMP or PCP resolver such as natpmp:// or
+// pcp://192.168.1.1. An empty host means the default gateway.
+type gatewayTarget struct {
+	protocol string
+	host     string
+	port     int
+}
+
+// parseGatewayResolver reports whether resolver uses a gateway protocol and,
+// if so, parses it.
+func parseGatewayResolver(resolver string) (target gatewayTarget, ok bool, err error) {
+	r, ok, err := parseHostResolver(resolver, protocolNATPMP, protocolPCP)
+	if !ok || err != nil {
+		return gatewayTarget{protocol: r.scheme}, ok, err
+	}
+
+	target = gatewayTarget{protocol: r.scheme, host: r.host, port: r.port}
+	if target.host != "" && net.ParseIP(target.host) == nil {
+		return target, true, fmt.Errorf("the gateway in %s must be an IP address", resolver)
+	}
+	if target.port == 0 {
+		target.port = gatewayPort
+	}
+
+	return target, true, nil
+}
+
+// lookupGateway is the strategy of natpmp:// and pcp:// resolvers.
+func lookupGateway(ctx context.Context, resolver string, opts lookupOptions) (*lookupResult, error) {
+	target, _, _ := parseGatewayResolver(resolver)
+	if opts.tunnel != nil {
+		return &lookupResult{resolverUsed: resolver}, errNotOverSSH(target.protocol)
+	}
+	return getExternalIPFromGateway(ctx, resolver, target, opts)
+}
+
+// getExternalIPFromGateway asks the local gateway for its external address
+// over NAT-PMP or PCP, so that no third-party service is involved.
+func getExternalIPFromGateway(ctx context.Context, resolver string, target gatewayTarget, opts lookupOptions) (result *lookupResult, err error) {
+	result = &lookupResult{resolverUsed: resolver}
+	start := time.Now()
+
+	logDebug(ctx, "Querying resolver", map[string]interface{}{
+		logFieldResolver: result.resolverUsed,
+		logFieldStrategy: target.protocol,
+		logFieldAttempt:  1,
+	})
+
+	defer func() {
+		result.latency = time.Since(start)
+		logLookupOutcome(ctx, result, err)
+	}()
+
+	if opts.clientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msToDuration(opts.clientTimeout))
+		defer cancel()
+	}
+
+	var answer string
+	if opts.mocks != nil {
+		var reason string
+		result.attempts = 1
+		// Mocked lookups skip discovery, so only a configured gateway is checked
+		if err = opts.policy.check(resolver, target.protocol, target.host); err == nil {
+			answer, reason, err = opts.mocks.lookupAnswer(ctx, resolver)
+		}
+		if reason != "" {
+			err = errors.New(reason)
+		}
+	} else {
+		answer, err = queryGateway(ctx, target, opts, result)
+	}
+	if opts.recorder != nil {
+		opts.recorder.recordAnswer(ctx, target.protocol, resolver, answer, err)
+	}
+	if err != nil {
+		return result, classifyGatewayError(resolver, target.protocol, err)
+	}
+
+	result.ip = answer
+	return result, nil
+}
+
+// queryGateway sends the request of target's protocol to the gateway,
+// discovering the default gateway when none is configured.
+func queryGateway(ctx context.Context, target gatewayTarget, opts lookupOptions, result *lookupResult) (string, error) {
+	host := target.host
+	if host == "" {
+		gateway, err := defaultGateway()
+		if err != nil {
+			return "", err
+		}
+		host = gateway.String()
+	}
+
+	if err := opts.policy.check(result.resolverUsed, target.protocol, host); err != nil {
+		return "", err
+	}
+
+	network := "udp4"
+	opts.network = "tcp4"
+	if net.ParseIP(host).To4() == nil {
+		network = "udp6"
+		opts.network = "tcp6"
+	}
+	opts, err := opts.bindSource()
+	if err != nil {
+		return "", err
+	}
+
+	dialer := &net.Dialer{LocalAddr: localAddr(network, opts.sourceAddress)}
+	conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(host, strconv.Itoa(target.port)))
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	result.remoteAddr = host
+	result.localAddr = hostFromAddr(conn.LocalAddr())
+
+	if target.protocol == protocolPCP {
+		return queryPCP(ctx, conn, result)
+	}
+	return gatewayExchange(ctx, conn, []byte{0, 0}, parseNATPMPResponse, result)
+}
+
+// queryPCP learns the external address from the answer to a short-lived PCP
+// MAP request, which it deletes again afterwards.
+func queryPCP(ctx context.Context, conn net.Conn, result *lookupResult) (string, error) {
+	local, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return "", fmt.Errorf("unexpected local address %s", conn.LocalAddr())
+	}
+
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	port := local.AddrPort().Port()
+	request := pcpMapRequest(local.IP, port, nonce, pcpMapLifetime)
+	answer, err := gatewayExchange(ctx, conn, request, parsePCPResponse(nonce), result)
+	if err == nil {
+		// Best effort: the gateway expires the mapping anyway
+		_, _ = conn.Write(pcpMapRequest(local.IP, port, nonce, 0))
+	}
+	return answer, err
+}
+
+// gatewayExchange sends request until parse accepts an answer, doubling the
+// wait between attempts. Datagrams parse does not recognise are skipped.
+func gatewayExchange(ctx context.Context, conn net.Conn, request []byte, parse func([]byte) (string, bool, error), result *lookupResult) (string, error) {
+	buf := make([]byte, 1100)
+	wait := gatewayInitialWait
+
+	for attempt := 1; attempt <= gatewayMaxAttempts; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return "", err
+		}
+		result.attempts = attempt
+
+		deadline := time.Now().Add(wait)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		if err := conn.SetReadDeadline(deadline); err != nil {
+			return "", err
+		}
+
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if isTimeout(err) {
+					break
+				}
+				return "", err
+			}
+			if answer, ok, err := parse(buf[:n]); ok {
+				return answer, err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		wait *= 2
+	}
+
+	return "", fmt.Errorf("no answer from the gateway after %d attempts: %w", gatewayMaxAttempts, os.ErrDeadlineExceeded)
+}
+
+// parseNATPMPResponse reads the answer to a NAT-PMP external address request.
+func parseNATPMPResponse(b []byte) (string, bool, error) {
+	if len(b) < 4 || b[0] != 0 || b[1] != 128 {
+		return "", false, nil
+	}
+	if code := binary.BigEndian.Uint16(b[2:4]); code != 0 {
+		return "", true, fmt.Errorf("gateway refused the NAT-PMP request: %s", resultName(natpmpResults[code], int(code)))
+	}
+	if len(b) < 12 {
+		return "", true, fmt.Errorf("NAT-PMP response too short: %d bytes", len(b))
+	}
+	return net.IP(b[8:12]).String(), true, nil
+}
+
+// pcpMapRequest builds a PCP MAP request for a UDP mapping of the local
+// port, leaving the choice of external port and address to the gateway.
+func pcpMapRequest(client net.IP, port uint16, nonce []byte, lifetime uint32) []byte {
+	b := make([]byte, 60)
+	b[0] = pcpVersion
+	b[1] = pcpOpMap
+	binary.BigEndian.PutUint32(b[4:8], lifetime)
+	copy(b[8:24], client.To16())
+
+	copy(b[24:36], nonce)
+	b[36] = 17 // UDP
+	binary.BigEndian.PutUint16(b[40:42], port)
+	if client.To4() != nil {
+		copy(b[44:60], net.IPv4zero.To16())
+	}
+	return b
+}
+
+// parsePCPResponse returns a parser for the answer to the MAP request
+// carrying nonce.
+func parsePCPResponse(nonce []byte) func([]byte) (string, bool, error) {
+	return func(b []byte) (string, bool, error) {
+		if len(b) < 24 || b[1] != 0x80|pcpOpMap {
+			return "", false, nil
+		}
+		if code := b[3]; code != 0 {
+			return "", true, fmt.Errorf("gateway refused the PCP request: %s", resultName(pcpResults[code], int(code)))
+		}
+		if len(b) < 60 || !bytes.Equal(b[24:36], nonce) {
+			return "", false, nil
+		}
+
+		ip := net.IP(b[44:60])
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		return ip.String(), true, nil
+	}
+}
+
+func resultName(name string, code int) string {
+	if name == "" {
+		return fmt.Sprintf("result code %d", code)
+	}
+	return fmt.Sprintf("%s (result code %d)", name, code)
+}
+
+// classifyGatewayError turns a gateway query failure into a typed error.
+func classifyGatewayError(resolver, protocol string, err error) error {
+	var (
+		policyErr *PolicyError
+		sourceErr *SourceError
+		opErr     *net.OpError
+	)
+
+	switch {
+	case errors.As(err, &policyErr), errors.As(err, &sourceErr):
+		return err
+	case isTimeout(err):
+		phase := "NAT-PMP request"
+		if protocol == protocolPCP {
+			phase = "PCP request"
+		}
+		return &TimeoutError{Resolver: resolver, Phase: phase, Setting: "client_timeout", Err: err}
+	case errors.As(err, &opErr):
+		return &ConnectionError{Resolver: resolver, Err: err}
+	default:
+		return &ResponseError{Resolver: resolver, Setting: "resolver", Reason: err.Error()}
+	}
+}
+
+// defaultGateway returns the IPv4 default gateway from the Linux routing
+// table. Other systems need the gateway set in the resolver.
+func defaultGateway() (net.IP, error) {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return nil, fmt.Errorf("could not discover the default gateway, set it in the resolver (such as natpmp://192.168.1.1): %w", err)
+	}
+	defer f.Close()
+
+	gateway, err := parseRouteTable(f)
+	if err != nil {
+		return nil, fmt.Errorf("could not discover the default gateway, set it in the resolver (such as natpmp://192.168.1.1): %w", err)
+	}
+	return gateway, nil
+}
+
+// parseRouteTable finds the default route with the lowest metric in the
+// format of /proc/net/route.
+func parseRouteTable(r io.Reader) (net.IP, error) {
+	var (
+		gateway    net.IP
+		bestMetric uint64
+	)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		ip, metric, ok := parseDefaultRoute(strings.Fields(scanner.Text()))
+		if ok && (gateway == nil || metric < bestMetric) {
+			gateway, bestMetric = ip, metric
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if gateway == nil {
+		return nil, errors.New("no default route")
+	}
+	return gateway, nil
+}
+
+// parseDefaultRoute returns the gateway and metric of a /proc/net/route line,
+// where addresses are hex in host byte order. ok is false for other routes.
+func parseDefaultRoute(fields []string) (gateway net.IP, metric uint64, ok bool) {
+	const rtfGateway = 0x2
+
+	if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+		return nil, 0, false
+	}
+
+	flags, err := strconv.ParseUint(fields[3], 16, 16)
+	if err != nil || flags&rtfGateway == 0 {
+		return nil, 0, false
+	}
+	raw, err := hex.DecodeString(fields[2])
+	if err != nil || len(raw) != 4 {
+		return nil, 0, false
+	}
+	metric, err = strconv.ParseUint(fields[6], 10, 32)
+	if err != nil {
+		return nil, 0, false
+	}
+
+	gateway = make(net.IP, 4)
+	binary.NativeEndian.PutUint32(gateway, binary.BigEndian.Uint32(raw))
+	return gateway, metric, true
+}
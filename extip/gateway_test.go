@@ -0,0 +1,290 @@
+package extip
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// startGatewayStandIn answers NAT-PMP and PCP requests on a local UDP socket
+// with the datagrams reply returns, none to drop a request. It returns the
+// address to use in a resolver URL.
+func startGatewayStandIn(t *testing.T, reply func(request []byte) [][]byte) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1100)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			for _, response := range reply(append([]byte{}, buf[:n]...)) {
+				_, _ = conn.WriteTo(response, addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func natpmpReply(code uint16, external net.IP) [][]byte {
+	return [][]byte{natpmpResponse(code, external)}
+}
+
+func natpmpResponse(code uint16, external net.IP) []byte {
+	b := []byte{0, 128}
+	b = binary.BigEndian.AppendUint16(b, code)
+	b = binary.BigEndian.AppendUint32(b, 1234) // seconds since the mapping table was reset
+	return append(b, external.To4()...)
+}
+
+func pcpReply(request []byte, code byte, external net.IP) []byte {
+	b := make([]byte, 60)
+	b[0] = pcpVersion
+	b[1] = 0x80 | pcpOpMap
+	b[3] = code
+	copy(b[24:60], request[24:60])
+	copy(b[44:60], external.To16())
+	return b
+}
+
+func TestParseGatewayResolver(t *testing.T) {
+	tests := []struct {
+		resolver string
+		ok       bool
+		target   gatewayTarget
+		wantErr  bool
+	}{
+		{"natpmp://", true, gatewayTarget{protocol: protocolNATPMP, port: gatewayPort}, false},
+		{"pcp://192.168.1.1", true, gatewayTarget{protocol: protocolPCP, host: "192.168.1.1", port: gatewayPort}, false},
+		{"NATPMP://10.0.0.1:15351/", true, gatewayTarget{protocol: protocolNATPMP, host: "10.0.0.1", port: 15351}, false},
+		{"pcp://[fe80::1]", true, gatewayTarget{protocol: protocolPCP, host: "fe80::1", port: gatewayPort}, false},
+		{"natpmp://router.lan", true, gatewayTarget{}, true},
+		{"natpmp://10.0.0.1/path", true, gatewayTarget{}, true},
+		{"pcp://10.0.0.1:0", true, gatewayTarget{}, true},
+		{"https://checkip.amazonaws.com/", false, gatewayTarget{}, false},
+		{"aws", false, gatewayTarget{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resolver, func(t *testing.T) {
+			target, ok, err := parseGatewayResolver(tt.resolver)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok %v, got %v", tt.ok, ok)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got: %v", tt.wantErr, err)
+			}
+			if !tt.wantErr && target != tt.target {
+				t.Errorf("Expected %+v, got %+v", tt.target, target)
+			}
+		})
+	}
+}
+
+func TestValidateResolverGateway(t *testing.T) {
+	for _, resolver := range []string{"natpmp://", "pcp://192.168.1.1:5351"} {
+		if _, errs := validateResolver(resolver, "resolver"); len(errs) != 0 {
+			t.Errorf("Expected %s to be valid, got: %v", resolver, errs)
+		}
+	}
+
+	if _, errs := validateResolver("natpmp://router.lan", "resolver"); len(errs) == 0 {
+		t.Error("Expected a gateway host name to be rejected")
+	}
+}
+
+func TestGetExternalIPFromNATPMP(t *testing.T) {
+	server := startGatewayStandIn(t, func(request []byte) [][]byte {
+		if len(request) != 2 || request[0] != 0 || request[1] != 0 {
+			return nil
+		}
+		return natpmpReply(0, net.ParseIP("203.0.113.7"))
+	})
+
+	result, err := lookupExternalIP(context.Background(), "natpmp://"+server, lookupOptions{clientTimeout: 1000})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if result.ip != "203.0.113.7" {
+		t.Errorf("Expected 203.0.113.7, got: %s", result.ip)
+	}
+	if result.remoteAddr != testIP || result.localAddr != testIP {
+		t.Errorf("Expected remote and local address %s, got %s and %s", testIP, result.remoteAddr, result.localAddr)
+	}
+	if result.attempts != 1 {
+		t.Errorf("Expected 1 attempt, got: %d", result.attempts)
+	}
+}
+
+func TestGetExternalIPFromNATPMPRetransmits(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	server := startGatewayStandIn(t, func([]byte) [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		requests++
+		if requests == 1 {
+			return nil
+		}
+		return natpmpReply(0, net.ParseIP("203.0.113.8"))
+	})
+
+	result, err := lookupExternalIP(context.Background(), "natpmp://"+server, lookupOptions{clientTimeout: 2000})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.8" || result.attempts != 2 {
+		t.Errorf("Expected 203.0.113.8 after 2 attempts, got %s after %d", result.ip, result.attempts)
+	}
+}
+
+func TestGetExternalIPFromNATPMPRefused(t *testing.T) {
+	server := startGatewayStandIn(t, func([]byte) [][]byte {
+		return natpmpReply(2, net.IPv4zero)
+	})
+
+	_, err := lookupExternalIP(context.Background(), "natpmp://"+server, lookupOptions{clientTimeout: 1000})
+
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || !strings.Contains(err.Error(), "not authorized or refused (result code 2)") {
+		t.Errorf("Expected the result code to be reported, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromGatewayTimeout(t *testing.T) {
+	server := startGatewayStandIn(t, func([]byte) [][]byte { return nil })
+
+	_, err := lookupExternalIP(context.Background(), "pcp://"+server, lookupOptions{clientTimeout: 300})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected a timeout, got: %v", err)
+	}
+	if timeoutErr.Phase != "PCP request" || timeoutErr.Setting != "client_timeout" {
+		t.Errorf("Expected a PCP request timeout bounded by client_timeout, got %+v", timeoutErr)
+	}
+}
+
+func TestGetExternalIPFromPCP(t *testing.T) {
+	lifetimes := make(chan uint32, 2)
+	server := startGatewayStandIn(t, func(request []byte) [][]byte {
+		if len(request) != 60 || request[0] != pcpVersion || request[1] != pcpOpMap || request[36] != 17 {
+			return nil
+		}
+		lifetimes <- binary.BigEndian.Uint32(request[4:8])
+
+		// An answer to another request comes first and must be skipped
+		stray := pcpReply(request, 0, net.ParseIP("198.51.100.1"))
+		stray[24] ^= 0xff
+		return [][]byte{stray, pcpReply(request, 0, net.ParseIP("203.0.113.9"))}
+	})
+
+	result, err := lookupExternalIP(context.Background(), "pcp://"+server, lookupOptions{clientTimeout: 1000})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.9" {
+		t.Errorf("Expected 203.0.113.9, got: %s", result.ip)
+	}
+
+	// The mapping is deleted with a zero lifetime once the address is known
+	for _, want := range []uint32{pcpMapLifetime, 0} {
+		select {
+		case got := <-lifetimes:
+			if got != want {
+				t.Errorf("Expected lifetime %d, got %d", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Expected a request with lifetime %d", want)
+		}
+	}
+}
+
+func TestParsePCPResponse(t *testing.T) {
+	nonce := []byte("0123456789ab")
+	request := pcpMapRequest(net.ParseIP("192.168.1.10"), 40000, nonce, pcpMapLifetime)
+	parse := parsePCPResponse(nonce)
+
+	if ip, ok, err := parse(pcpReply(request, 0, net.ParseIP("203.0.113.10"))); !ok || err != nil || ip != "203.0.113.10" {
+		t.Errorf("Expected 203.0.113.10, got %q, %v, %v", ip, ok, err)
+	}
+
+	other := pcpReply(request, 0, net.ParseIP("203.0.113.10"))
+	copy(other[24:36], "another-one!")
+	if _, ok, _ := parse(other); ok {
+		t.Error("Expected an answer with another nonce to be skipped")
+	}
+
+	if _, ok, err := parse(pcpReply(request, 1, net.IPv4zero)); !ok || err == nil || !strings.Contains(err.Error(), "natpmp://") {
+		t.Errorf("Expected unsupported version to suggest natpmp://, got: %v", err)
+	}
+
+	if _, ok, _ := parse(natpmpResponse(0, net.ParseIP("203.0.113.10"))); ok {
+		t.Error("Expected a NAT-PMP answer to be skipped")
+	}
+}
+
+func TestParseRouteTable(t *testing.T) {
+	// The kernel prints addresses in host byte order
+	hex := func(ip string) string {
+		return fmt.Sprintf("%08X", binary.NativeEndian.Uint32(net.ParseIP(ip).To4()))
+	}
+
+	table := "Iface\tDestination\tGateway \tFlags\tRefCnt\tUse\tMetric\tMask\t\tMTU\tWindow\tIRTT\n" +
+		"eth0\t" + hex("192.168.1.0") + "\t00000000\t0001\t0\t0\t100\t" + hex("255.255.255.0") + "\t0\t0\t0\n" +
+		"wlan0\t00000000\t" + hex("192.168.1.254") + "\t0003\t0\t0\t600\t00000000\t0\t0\t0\n" +
+		"eth0\t00000000\t" + hex("192.168.1.1") + "\t0003\t0\t0\t100\t00000000\t0\t0\t0\n"
+
+	gateway, err := parseRouteTable(strings.NewReader(table))
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if !gateway.Equal(net.ParseIP("192.168.1.1")) {
+		t.Errorf("Expected the default route with the lowest metric, got %s", gateway)
+	}
+
+	if _, err := parseRouteTable(strings.NewReader("Iface\tDestination\n")); err == nil {
+		t.Error("Expected an error without a default route")
+	}
+}
+
+func TestGatewayResolverMocked(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{"resolver": "natpmp://", "body": "192.0.2.30"})
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "natpmp://"})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "192.0.2.30" {
+		t.Errorf("Expected the mocked address, got %v", d.Get("ipaddress"))
+	}
+}
+
+func TestGatewayResolverPolicy(t *testing.T) {
+	server := startGatewayStandIn(t, func([]byte) [][]byte { return natpmpReply(0, net.ParseIP("203.0.113.11")) })
+
+	opts := lookupOptions{clientTimeout: 1000, policy: &resolverPolicy{requireHTTPS: true}}
+	_, err := lookupExternalIP(context.Background(), "natpmp://"+server, opts)
+
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Setting != "require_https" {
+		t.Errorf("Expected require_https to refuse the gateway, got: %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package extip
+
+import (
+	"context"
+	"regexp"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+)
+
+// logSubsystem is the tflog subsystem used for resolver traffic. Its level
+// can be set on its own with TF_LOG_PROVIDER_EXTIP_RESOLVER.
+const logSubsystem = "resolver"
+
+// Log field keys shared by the resolver log entries.
+const (
+	logFieldResolver   = "resolver"
+	logFieldStrategy   = "strategy"
+	logFieldAttempt    = "attempt"
+	logFieldStatus     = "http_status"
+	logFieldLatency    = "latency_ms"
+	logFieldRemoteAddr = "remote_addr"
+	logFieldTLSVersion = "tls_version"
+	logFieldIPAddress  = "ipaddress"
+	logFieldError      = "error"
+)
+
+// sensitiveLogFields are masked in every resolver log entry. The discovered
+// address identifies the user's network, so it is kept out of shared logs.
+var sensitiveLogFields = []string{logFieldIPAddress}
+
+// credentialsPattern matches user info embedded in URLs.
+var credentialsPattern = regexp.MustCompile(`//[^/@\s]+@`)
+
+// withResolverLogging sets up the resolver log subsystem on ctx.
+func withResolverLogging(ctx context.Context) context.Context {
+	ctx = tflog.NewSubsystem(ctx, logSubsystem, tflog.WithLevelFromEnv("TF_LOG_PROVIDER_EXTIP", logSubsystem))
+	ctx = tflog.SubsystemMaskFieldValuesWithFieldKeys(ctx, logSubsystem, sensitiveLogFields...)
+	ctx = tflog.SubsystemMaskAllFieldValuesRegexes(ctx, logSubsystem, credentialsPattern)
+	ctx = tflog.SubsystemMaskMessageRegexes(ctx, logSubsystem, credentialsPattern)
+	return ctx
+}
+
+func logDebug(ctx context.Context, msg string, fields map[string]interface{}) {
+	tflog.SubsystemDebug(ctx, logSubsystem, msg, fields)
+}
+
+func logWarn(ctx context.Context, msg string, fields map[string]interface{}) {
+	tflog.SubsystemWarn(ctx, logSubsystem, msg, fields)
+}
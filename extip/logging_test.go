@@ -0,0 +1,97 @@
+package extip
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-log/tflogtest"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestDataSourceReadLogsResolverCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("203.0.113.7"))
+	}))
+	defer server.Close()
+
+	var output bytes.Buffer
+	ctx := tflogtest.RootLogger(context.Background(), &output)
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":    "http://user:secret@" + strings.TrimPrefix(server.URL, "http://"),
+		"validate_ip": true,
+	})
+
+	if err := dataSourceRead(ctx, d, nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatalf("Failed to decode log output: %v", err)
+	}
+
+	messages := map[string]map[string]interface{}{}
+	for _, entry := range entries {
+		if entry["@module"] != "provider."+logSubsystem {
+			continue
+		}
+		messages[entry["@message"].(string)] = entry
+	}
+
+	for _, msg := range []string{"Querying resolver", "Resolver lookup succeeded", "Validated resolver response"} {
+		if _, ok := messages[msg]; !ok {
+			t.Errorf("Expected log entry %q, got: %v", msg, entries)
+		}
+	}
+
+	success := messages["Resolver lookup succeeded"]
+	if success[logFieldStatus] != float64(http.StatusOK) {
+		t.Errorf("Expected status field 200, got: %v", success[logFieldStatus])
+	}
+
+	if success[logFieldIPAddress] != "***" {
+		t.Errorf("Expected discovered address to be masked, got: %v", success[logFieldIPAddress])
+	}
+
+	if strings.Contains(output.String(), "secret") || strings.Contains(output.String(), "203.0.113.7") {
+		t.Errorf("Expected credentials and address to be masked, got: %s", output.String())
+	}
+
+	if messages["Querying resolver"][logFieldStrategy] != "http" {
+		t.Errorf("Expected strategy field, got: %v", messages["Querying resolver"])
+	}
+}
+
+func TestGetExternalIPFromLogsFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	var output bytes.Buffer
+	ctx := withResolverLogging(tflogtest.RootLogger(context.Background(), &output))
+
+	if _, err := getExternalIPFrom(ctx, server.URL, lookupOptions{}); err == nil {
+		t.Fatal("Expected error for 502 response")
+	}
+
+	entries, err := tflogtest.MultilineJSONDecode(&output)
+	if err != nil {
+		t.Fatalf("Failed to decode log output: %v", err)
+	}
+
+	for _, entry := range entries {
+		if entry["@message"] == "Resolver lookup failed" {
+			if entry["@level"] != "warn" || entry[logFieldStatus] != float64(http.StatusBadGateway) {
+				t.Errorf("Unexpected failure entry: %v", entry)
+			}
+			return
+		}
+	}
+	t.Errorf("Expected failure log entry, got: %v", entries)
+}
@@ -0,0 +1,378 @@
+package extip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// protocolMetadata is the protocol of resolvers that read the instance
+// metadata service of a cloud provider.
+const protocolMetadata = "metadata"
+
+const (
+	// imdsTokenTimeout bounds the IMDSv2 token request. Its answer is dropped
+	// when the instance's hop limit is too low, such as in a container, so
+	// waiting the whole client_timeout for it would only delay the IMDSv1
+	// fallback. The request never gets more than half of what is left of the
+	// lookup, see imdsTokenBudget.
+	imdsTokenTimeout = time.Second
+
+	// imdsTokenTTL is the lifetime requested for IMDSv2 tokens in seconds.
+	// A token is only used for the few requests of one lookup.
+	imdsTokenTTL = "60"
+
+	// maxMetadataResponseBytes caps metadata responses.
+	maxMetadataResponseBytes = 64 << 10
+
+	// azureIMDSAPIVersion is the Azure instance metadata API version used.
+	azureIMDSAPIVersion = "2021-02-01"
+)
+
+// metadataEndpoint returns the base URL of the metadata service entry reads,
+// honouring the metadata_endpoint override.
+func metadataEndpoint(entry resolverEntry, opts lookupOptions) string {
+	if opts.metadataEndpoint != "" {
+		return strings.TrimSuffix(opts.metadataEndpoint, "/")
+	}
+	return entry.url
+}
+
+// getExternalIPFromMetadata reads the instance's public address from the
+// metadata service of its cloud provider.
+func getExternalIPFromMetadata(ctx context.Context, entry resolverEntry, opts lookupOptions) (result *lookupResult, err error) {
+	endpoint := metadataEndpoint(entry, opts)
+	result = &lookupResult{resolverUsed: redactURL(endpoint)}
+	start := time.Now()
+
+	logDebug(ctx, "Querying resolver", map[string]interface{}{
+		logFieldResolver: result.resolverUsed,
+		logFieldStrategy: protocolMetadata,
+		logFieldAttempt:  1,
+	})
+
+	defer func() {
+		result.latency = time.Since(start)
+		logLookupOutcome(ctx, result, err)
+	}()
+
+	if opts.clientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msToDuration(opts.clientTimeout))
+		defer cancel()
+	}
+
+	opts, err = opts.bindSource()
+	if err != nil {
+		return result, err
+	}
+
+	var answer string
+	if opts.mocks != nil {
+		var reason string
+		result.attempts = 1
+		answer, reason, err = opts.mocks.lookupAnswer(ctx, entry.name)
+		if reason != "" {
+			err = errors.New(reason)
+		}
+	} else {
+		answer, err = queryMetadata(ctx, entry, endpoint, opts, result)
+	}
+	if opts.recorder != nil {
+		opts.recorder.recordAnswer(ctx, protocolMetadata, entry.name, answer, err)
+	}
+	if err != nil {
+		return result, classifyMetadataError(result.resolverUsed, err)
+	}
+
+	result.ip = answer
+	return result, nil
+}
+
+func queryMetadata(ctx context.Context, entry resolverEntry, endpoint string, opts lookupOptions, result *lookupResult) (string, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	// Metadata services are link-local, so proxies are deliberately skipped
+	// and redirects, which they never send, are not followed
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{
+				Timeout:   msToDuration(opts.dialTimeout),
+				LocalAddr: localAddr("tcp", opts.sourceAddress),
+			}).DialContext,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	if opts.tunnel != nil {
+		client.Transport.(*http.Transport).DialContext = opts.tunnel.dialContext("")
+	}
+	defer client.CloseIdleConnections()
+
+	c := &metadataClient{client: client, base: base, result: result}
+	switch entry.name {
+	case "aws-imds":
+		return c.awsPublicAddress(ctx)
+	case "gcp-metadata":
+		c.header = http.Header{"Metadata-Flavor": {"Google"}}
+		return c.gcpPublicAddress(ctx)
+	case "azure-imds":
+		c.header = http.Header{"Metadata": {"true"}}
+		return c.azurePublicAddress(ctx)
+	default:
+		return "", fmt.Errorf("no metadata client for %s", entry.name)
+	}
+}
+
+// metadataClient sends requests to a metadata service, recording the
+// connection and the number of requests in result.
+type metadataClient struct {
+	client *http.Client
+	base   *url.URL
+	header http.Header
+	result *lookupResult
+	// flavor is the Metadata-Flavor header of the last response.
+	flavor string
+}
+
+// do sends a request for path and returns the trimmed body and status.
+func (c *metadataClient) do(ctx context.Context, method, path string, header http.Header) (string, int, error) {
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			c.result.remoteAddr = hostFromAddr(info.Conn.RemoteAddr())
+			c.result.localAddr = hostFromAddr(info.Conn.LocalAddr())
+		},
+	})
+
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.base.String(), "/")+path, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	for _, h := range []http.Header{c.header, header} {
+		for name, values := range h {
+			req.Header[name] = values
+		}
+	}
+
+	c.result.attempts++
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	c.result.httpStatus = resp.StatusCode
+	c.flavor = resp.Header.Get("Metadata-Flavor")
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxMetadataResponseBytes))
+	if err != nil {
+		return "", 0, err
+	}
+	return strings.TrimSpace(string(body)), resp.StatusCode, nil
+}
+
+// awsPublicAddress reads the public IPv4 address of an EC2 instance, or the
+// first IPv6 address of its primary interface when it has no public IPv4.
+func (c *metadataClient) awsPublicAddress(ctx context.Context) (string, error) {
+	tokenTimedOut, err := c.awsToken(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if ip, err := c.awsPublicIPv4(ctx, tokenTimedOut); err != nil || ip != "" {
+		return ip, err
+	}
+	if ip, err := c.awsInterfaceIPv6(ctx); err != nil || ip != "" {
+		return ip, err
+	}
+
+	return "", errors.New("the instance has no public IPv4 address and no IPv6 address in its metadata. " +
+		"Assign it a public or Elastic IP, or use a resolver that sees its NAT address, such as aws")
+}
+
+// awsPublicIPv4 reads the public IPv4 address of the instance, which is empty
+// when it has none. tokenTimedOut explains a refusal for want of a token.
+func (c *metadataClient) awsPublicIPv4(ctx context.Context, tokenTimedOut bool) (string, error) {
+	ip, status, err := c.do(ctx, http.MethodGet, "/latest/meta-data/public-ipv4", nil)
+	switch {
+	case err != nil:
+		return "", err
+	case status == http.StatusOK:
+		return ip, nil
+	case status == http.StatusUnauthorized && tokenTimedOut:
+		return "", errors.New("the instance metadata service requires IMDSv2, but the token request got no answer. " +
+			"When running in a container, raise the instance's http-put-response-hop-limit to 2")
+	case status == http.StatusUnauthorized:
+		return "", errors.New("the instance metadata service refused the IMDSv2 token")
+	case status != http.StatusNotFound:
+		return "", &StatusError{Resolver: c.base.String(), StatusCode: status}
+	default:
+		return "", nil
+	}
+}
+
+// awsInterfaceIPv6 reads the first IPv6 address of the primary interface,
+// found by its MAC address, which is empty when it has none.
+func (c *metadataClient) awsInterfaceIPv6(ctx context.Context) (string, error) {
+	mac, status, err := c.do(ctx, http.MethodGet, "/latest/meta-data/mac", nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", &StatusError{Resolver: c.base.String(), StatusCode: status}
+	}
+
+	ipv6s, status, err := c.do(ctx, http.MethodGet, "/latest/meta-data/network/interfaces/macs/"+url.PathEscape(mac)+"/ipv6s", nil)
+	switch {
+	case err != nil:
+		return "", err
+	case status == http.StatusOK && ipv6s != "":
+		return strings.Fields(ipv6s)[0], nil
+	case status != http.StatusOK && status != http.StatusNotFound:
+		return "", &StatusError{Resolver: c.base.String(), StatusCode: status}
+	default:
+		return "", nil
+	}
+}
+
+// awsToken requests an IMDSv2 session token and sends it with every further
+// request. When the token request times out, or the endpoint only speaks
+// IMDSv1, requests are sent without a token, as the AWS SDKs do.
+func (c *metadataClient) awsToken(ctx context.Context) (timedOut bool, err error) {
+	tokenCtx, cancel := context.WithTimeout(ctx, imdsTokenBudget(ctx))
+	defer cancel()
+
+	token, status, err := c.do(tokenCtx, http.MethodPut, "/latest/api/token",
+		http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {imdsTokenTTL}})
+	switch {
+	case err != nil && isTimeout(err) && ctx.Err() == nil:
+		logDebug(ctx, "IMDSv2 token request timed out, falling back to IMDSv1", map[string]interface{}{
+			logFieldResolver: c.base.String(),
+		})
+		return true, nil
+	case err != nil:
+		return false, err
+	case status == http.StatusOK:
+		c.header = http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+		return false, nil
+	case status == http.StatusForbidden:
+		return false, errors.New("the instance metadata service is disabled on this instance")
+	default:
+		return false, nil
+	}
+}
+
+// imdsTokenBudget returns how long the IMDSv2 token request may take: at most
+// imdsTokenTimeout, and half of what is left until the deadline of ctx, so
+// that the IMDSv1 fallback still has time to run.
+func imdsTokenBudget(ctx context.Context) time.Duration {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return imdsTokenTimeout
+	}
+	return min(imdsTokenTimeout, time.Until(deadline)/2)
+}
+
+// gcpPublicAddress reads the external IPv4 address of the first access
+// config of the primary interface of a Compute Engine instance, or its
+// external IPv6 address when it has no external IPv4.
+func (c *metadataClient) gcpPublicAddress(ctx context.Context) (string, error) {
+	paths := []string{
+		"/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip",
+		"/computeMetadata/v1/instance/network-interfaces/0/ipv6-access-configs/0/external-ipv6",
+	}
+
+	for _, path := range paths {
+		ip, status, err := c.do(ctx, http.MethodGet, path, nil)
+		switch {
+		case err != nil:
+			return "", err
+		case c.flavor != "Google":
+			return "", fmt.Errorf("%s is not a Compute Engine metadata server: the Metadata-Flavor response header is missing", c.base)
+		case status == http.StatusOK && ip != "":
+			return ip, nil
+		case status != http.StatusOK && status != http.StatusNotFound:
+			return "", &StatusError{Resolver: c.base.String(), StatusCode: status}
+		}
+	}
+
+	return "", errors.New("the primary interface of the instance has no external IPv4 or IPv6 address in its metadata. " +
+		"Give it an external IP, or use a resolver that sees its NAT address, such as aws")
+}
+
+// azureNetwork is the part of the Azure instance network metadata that
+// holds public addresses.
+type azureNetwork struct {
+	Interface []struct {
+		IPv4 azureAddresses `json:"ipv4"`
+		IPv6 azureAddresses `json:"ipv6"`
+	} `json:"interface"`
+}
+
+type azureAddresses struct {
+	IPAddress []struct {
+		PublicIPAddress string `json:"publicIpAddress"`
+	} `json:"ipAddress"`
+}
+
+// azurePublicAddress reads the first public IPv4 address of the primary
+// interface of an Azure VM, or its public IPv6 address when it has none.
+func (c *metadataClient) azurePublicAddress(ctx context.Context) (string, error) {
+	body, status, err := c.do(ctx, http.MethodGet, "/metadata/instance/network?api-version="+azureIMDSAPIVersion, nil)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK {
+		return "", &StatusError{Resolver: c.base.String(), StatusCode: status}
+	}
+
+	var network azureNetwork
+	if err := json.Unmarshal([]byte(body), &network); err != nil {
+		return "", fmt.Errorf("could not parse the instance network metadata: %w", err)
+	}
+
+	if len(network.Interface) > 0 {
+		primary := network.Interface[0]
+		for _, addresses := range []azureAddresses{primary.IPv4, primary.IPv6} {
+			for _, address := range addresses.IPAddress {
+				if address.PublicIPAddress != "" {
+					return address.PublicIPAddress, nil
+				}
+			}
+		}
+	}
+
+	return "", errors.New("the primary interface of the VM has no public IPv4 or IPv6 address in its metadata. " +
+		"Azure only reports public IPs attached directly to the interface, so behind a load balancer or NAT gateway " +
+		"use a resolver that sees the NAT address, such as aws")
+}
+
+// classifyMetadataError turns a metadata lookup failure into a typed error.
+func classifyMetadataError(resolver string, err error) error {
+	var (
+		policyErr *PolicyError
+		sourceErr *SourceError
+		statusErr *StatusError
+		opErr     *net.OpError
+	)
+
+	switch {
+	case errors.As(err, &policyErr), errors.As(err, &sourceErr), errors.As(err, &statusErr):
+		return err
+	case isTimeout(err):
+		return &TimeoutError{Resolver: resolver, Phase: "metadata request", Setting: "client_timeout", Err: err}
+	case errors.As(err, &opErr):
+		return &ConnectionError{Resolver: resolver, Err: err}
+	default:
+		return &ResponseError{Resolver: resolver, Setting: "resolver", Reason: err.Error()}
+	}
+}
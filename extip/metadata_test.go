@@ -0,0 +1,390 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// imdsStandIn mimics the EC2 instance metadata service.
+type imdsStandIn struct {
+	publicIPv4 string
+	ipv6s      string
+	// requireToken rejects requests without a token, like HttpTokens=required.
+	requireToken bool
+	// tokenDelay holds the token answer back, like a hop limit that drops it.
+	tokenDelay time.Duration
+	// tokenStatus replaces the token answer when set.
+	tokenStatus int
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *imdsStandIn) start(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+		s.mu.Unlock()
+
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			select {
+			case <-time.After(s.tokenDelay):
+			case <-r.Context().Done():
+				return
+			}
+			if s.tokenStatus != 0 {
+				w.WriteHeader(s.tokenStatus)
+				return
+			}
+			_, _ = io.WriteString(w, "test-token")
+			return
+		}
+
+		token := r.Header.Get("X-aws-ec2-metadata-token")
+		if (s.requireToken && token == "") || (token != "" && token != "test-token") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.URL.Path == "/latest/meta-data/public-ipv4" && s.publicIPv4 != "":
+			_, _ = io.WriteString(w, s.publicIPv4)
+		case r.URL.Path == "/latest/meta-data/mac":
+			_, _ = io.WriteString(w, "0e:49:61:0f:c3:11")
+		case r.URL.Path == "/latest/meta-data/network/interfaces/macs/0e:49:61:0f:c3:11/ipv6s" && s.ipv6s != "":
+			_, _ = io.WriteString(w, s.ipv6s)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func lookupAWSIMDS(t *testing.T, endpoint string, clientTimeout int) (*lookupResult, error) {
+	t.Helper()
+
+	entry, ok := catalogEntry("aws-imds")
+	if !ok {
+		t.Fatal("Expected aws-imds in the catalog")
+	}
+	return getExternalIPFromMetadata(context.Background(), entry, lookupOptions{
+		clientTimeout:    clientTimeout,
+		metadataEndpoint: endpoint,
+	})
+}
+
+func TestAWSIMDSPublicIPv4(t *testing.T) {
+	imds := &imdsStandIn{publicIPv4: "203.0.113.40", requireToken: true}
+
+	result, err := lookupAWSIMDS(t, imds.start(t), 1000)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if result.ip != "203.0.113.40" {
+		t.Errorf("Expected 203.0.113.40, got: %s", result.ip)
+	}
+	if result.attempts != 2 {
+		t.Errorf("Expected a token request and a metadata request, got %d requests: %v", result.attempts, imds.requests)
+	}
+	if result.remoteAddr != testIP {
+		t.Errorf("Expected remote address %s, got: %s", testIP, result.remoteAddr)
+	}
+}
+
+func TestAWSIMDSIPv6Only(t *testing.T) {
+	imds := &imdsStandIn{ipv6s: "2001:db8::40\n2001:db8::41", requireToken: true}
+
+	result, err := lookupAWSIMDS(t, imds.start(t), 1000)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "2001:db8::40" {
+		t.Errorf("Expected the first IPv6 address, got: %s", result.ip)
+	}
+}
+
+func TestAWSIMDSNoPublicAddress(t *testing.T) {
+	imds := &imdsStandIn{}
+
+	_, err := lookupAWSIMDS(t, imds.start(t), 1000)
+
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || !strings.Contains(err.Error(), "no public IPv4 address and no IPv6 address") {
+		t.Errorf("Expected a missing public address error, got: %v", err)
+	}
+}
+
+func TestAWSIMDSTokenTimeoutFallsBackToIMDSv1(t *testing.T) {
+	// The token request must give up early enough for the fallback to run
+	// within client_timeout, including its default
+	for _, clientTimeout := range []int{defaultClientTimeout, 3000} {
+		t.Run(strconv.Itoa(clientTimeout), func(t *testing.T) {
+			imds := &imdsStandIn{publicIPv4: "203.0.113.41", tokenDelay: 5 * time.Second}
+
+			start := time.Now()
+			result, err := lookupAWSIMDS(t, imds.start(t), clientTimeout)
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if result.ip != "203.0.113.41" {
+				t.Errorf("Expected 203.0.113.41, got: %s", result.ip)
+			}
+			if elapsed := time.Since(start); elapsed > 2*time.Second {
+				t.Errorf("Expected the token request to give up early, took %s", elapsed)
+			}
+		})
+	}
+}
+
+func TestAWSIMDSTokenTimeoutHopLimitHint(t *testing.T) {
+	for _, clientTimeout := range []int{defaultClientTimeout, 3000} {
+		t.Run(strconv.Itoa(clientTimeout), func(t *testing.T) {
+			imds := &imdsStandIn{publicIPv4: "203.0.113.42", requireToken: true, tokenDelay: 5 * time.Second}
+
+			_, err := lookupAWSIMDS(t, imds.start(t), clientTimeout)
+			if err == nil || !strings.Contains(err.Error(), "http-put-response-hop-limit") {
+				t.Errorf("Expected a hop limit hint, got: %v", err)
+			}
+		})
+	}
+}
+
+func TestIMDSTokenBudget(t *testing.T) {
+	if got := imdsTokenBudget(context.Background()); got != imdsTokenTimeout {
+		t.Errorf("Expected %s without a deadline, got %s", imdsTokenTimeout, got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), msToDuration(defaultClientTimeout))
+	defer cancel()
+	if got := imdsTokenBudget(ctx); got > msToDuration(defaultClientTimeout)/2 {
+		t.Errorf("Expected at most half of client_timeout, got %s", got)
+	}
+}
+
+func TestAWSIMDSDisabled(t *testing.T) {
+	imds := &imdsStandIn{publicIPv4: "203.0.113.43", tokenStatus: http.StatusForbidden}
+
+	_, err := lookupAWSIMDS(t, imds.start(t), 1000)
+	if err == nil || !strings.Contains(err.Error(), "disabled") {
+		t.Errorf("Expected a disabled metadata service error, got: %v", err)
+	}
+}
+
+func TestAWSIMDSUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	endpoint := server.URL
+	server.Close()
+
+	_, err := lookupAWSIMDS(t, endpoint, 1000)
+
+	var connectionErr *ConnectionError
+	if !errors.As(err, &connectionErr) {
+		t.Errorf("Expected a connection error, got: %v", err)
+	}
+}
+
+func TestAWSIMDSDataSource(t *testing.T) {
+	imds := &imdsStandIn{publicIPv4: "203.0.113.44", requireToken: true}
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":          "aws-imds",
+		"metadata_endpoint": imds.start(t) + "/",
+	})
+	if err := dataSourceRead(context.Background(), d, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "203.0.113.44" {
+		t.Errorf("Expected 203.0.113.44, got %v", d.Get("ipaddress"))
+	}
+}
+
+func TestAWSIMDSMocked(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{"resolver": "aws-imds", "body": "192.0.2.40"})
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "aws-imds"})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "192.0.2.40" {
+		t.Errorf("Expected the mocked address, got %v", d.Get("ipaddress"))
+	}
+}
+
+func lookupMetadata(t *testing.T, name, endpoint string) (*lookupResult, error) {
+	t.Helper()
+
+	entry, ok := catalogEntry(name)
+	if !ok {
+		t.Fatalf("Expected %s in the catalog", name)
+	}
+	return getExternalIPFromMetadata(context.Background(), entry, lookupOptions{clientTimeout: 1000, metadataEndpoint: endpoint})
+}
+
+// startGCPStandIn mimics the Compute Engine metadata server, answering paths
+// with the values in paths.
+func startGCPStandIn(t *testing.T, paths map[string]string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Metadata-Flavor", "Google")
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		value, ok := paths[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = io.WriteString(w, value)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestGCPMetadata(t *testing.T) {
+	tests := []struct {
+		name  string
+		paths map[string]string
+		want  string
+	}{
+		{
+			name: "external IPv4",
+			paths: map[string]string{
+				"/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip":        "203.0.113.50",
+				"/computeMetadata/v1/instance/network-interfaces/0/ipv6-access-configs/0/external-ipv6": "2001:db8::50",
+			},
+			want: "203.0.113.50",
+		},
+		{
+			name: "access config without external IPv4",
+			paths: map[string]string{
+				"/computeMetadata/v1/instance/network-interfaces/0/access-configs/0/external-ip":        "",
+				"/computeMetadata/v1/instance/network-interfaces/0/ipv6-access-configs/0/external-ipv6": "2001:db8::51",
+			},
+			want: "2001:db8::51",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := lookupMetadata(t, "gcp-metadata", startGCPStandIn(t, tt.paths))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if result.ip != tt.want {
+				t.Errorf("Expected %s, got: %s", tt.want, result.ip)
+			}
+		})
+	}
+}
+
+func TestGCPMetadataNoExternalAddress(t *testing.T) {
+	_, err := lookupMetadata(t, "gcp-metadata", startGCPStandIn(t, nil))
+	if err == nil || !strings.Contains(err.Error(), "no external IPv4 or IPv6 address") {
+		t.Errorf("Expected a missing external address error, got: %v", err)
+	}
+}
+
+func TestGCPMetadataWrongServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "203.0.113.52")
+	}))
+	defer server.Close()
+
+	_, err := lookupMetadata(t, "gcp-metadata", server.URL)
+	if err == nil || !strings.Contains(err.Error(), "not a Compute Engine metadata server") {
+		t.Errorf("Expected a response without Metadata-Flavor to be rejected, got: %v", err)
+	}
+}
+
+// startAzureStandIn mimics the Azure instance metadata service, answering
+// the network metadata request with network.
+func startAzureStandIn(t *testing.T, network string) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata") != "true" {
+			http.Error(w, `{"error": "Bad request. Required metadata header not specified"}`, http.StatusBadRequest)
+			return
+		}
+		if r.URL.Path != "/metadata/instance/network" || r.URL.Query().Get("api-version") == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_, _ = io.WriteString(w, network)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL
+}
+
+func TestAzureIMDS(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		want    string
+	}{
+		{
+			name: "public IPv4",
+			network: `{"interface": [
+				{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4", "publicIpAddress": "203.0.113.60"}]},
+				 "ipv6": {"ipAddress": []}, "macAddress": "000D3AF806EC"},
+				{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.1.4", "publicIpAddress": "203.0.113.61"}]}}
+			]}`,
+			want: "203.0.113.60",
+		},
+		{
+			name: "public IPv6 only",
+			network: `{"interface": [
+				{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4", "publicIpAddress": ""}]},
+				 "ipv6": {"ipAddress": [{"privateIpAddress": "fd00::4", "publicIpAddress": "2001:db8::60"}]}}
+			]}`,
+			want: "2001:db8::60",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := lookupMetadata(t, "azure-imds", startAzureStandIn(t, tt.network))
+			if err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+			if result.ip != tt.want {
+				t.Errorf("Expected %s, got: %s", tt.want, result.ip)
+			}
+		})
+	}
+}
+
+func TestAzureIMDSNoPublicAddress(t *testing.T) {
+	network := `{"interface": [{"ipv4": {"ipAddress": [{"privateIpAddress": "10.0.0.4", "publicIpAddress": ""}]}}]}`
+
+	_, err := lookupMetadata(t, "azure-imds", startAzureStandIn(t, network))
+
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || !strings.Contains(err.Error(), "no public IPv4 or IPv6 address") {
+		t.Errorf("Expected a missing public address error, got: %v", err)
+	}
+}
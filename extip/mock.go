@@ -0,0 +1,245 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// mockResponseSchema describes one canned answer of the provider
+// mock_responses block.
+func mockResponseSchema() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"resolver": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "The resolver URL or built-in resolver name this response answers",
+				ValidateFunc: validateResolver,
+			},
+			"status_code": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      http.StatusOK,
+				Description:  "The HTTP status code of the response\nIf not set, defaults to 200. For DNS resolvers, any other code fails the lookup",
+				ValidateFunc: validation.IntBetween(100, 599),
+			},
+			"body": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The response body. For DNS resolvers, the record value",
+			},
+			"headers": {
+				Type:        schema.TypeMap,
+				Optional:    true,
+				Description: "Response headers, such as Content-Type or Location",
+				Elem: &schema.Schema{
+					Type: schema.TypeString,
+				},
+			},
+			"delay_ms": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				Description:  "How long to wait before answering in ms, to exercise timeouts",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+		},
+	}
+}
+
+// mockResponse is a canned resolver answer.
+type mockResponse struct {
+	statusCode int
+	body       string
+	headers    map[string]string
+	delay      time.Duration
+	// failure, when set, is returned as the lookup error instead of a response.
+	failure string
+}
+
+// mockResponses serves lookups from canned answers instead of the network.
+// HTTP resolvers are keyed by URL, other resolvers by their name or URL.
+// A resolver with several answers gets them in order, the last one repeating.
+// Lookups of a resolver without a canned answer fail, so that a mocked
+// provider never reaches the network.
+type mockResponses struct {
+	mu        sync.Mutex
+	responses map[string][]mockResponse
+	served    map[string]int
+	// scrubbed means HTTP answers are keyed by scrubbed URLs, as in record files.
+	scrubbed bool
+}
+
+// expandMockResponses builds the canned answers from the provider
+// mock_responses block. It returns nil when the block is not set.
+func expandMockResponses(d *schema.ResourceData) (*mockResponses, error) {
+	raw, ok := d.Get("mock_responses").([]interface{})
+	if !ok {
+		return nil, errors.New("mock_responses is not a list")
+	}
+	if len(raw) == 0 {
+		return nil, nil
+	}
+
+	mocks := &mockResponses{responses: make(map[string][]mockResponse, len(raw))}
+	for i, item := range raw {
+		block, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("mock_responses.%d is not a block", i)
+		}
+
+		resolver, _ := block["resolver"].(string)
+		statusCode, _ := block["status_code"].(int)
+		body, _ := block["body"].(string)
+		delay, _ := block["delay_ms"].(int)
+
+		headers := make(map[string]string)
+		if rawHeaders, ok := block["headers"].(map[string]interface{}); ok {
+			for name, value := range rawHeaders {
+				headers[name], _ = value.(string)
+			}
+		}
+
+		key := resolver
+		if entry, ok := catalogEntry(resolver); ok && entry.protocol == protocolHTTPS {
+			key = entry.url
+		}
+		if len(mocks.responses[key]) > 0 {
+			return nil, fmt.Errorf("mock_responses.%d: duplicate response for resolver %s", i, resolver)
+		}
+
+		mocks.responses[key] = []mockResponse{{
+			statusCode: statusCode,
+			body:       body,
+			headers:    headers,
+			delay:      msToDuration(delay),
+		}}
+	}
+
+	return mocks, nil
+}
+
+// RoundTrip answers an HTTP request with its canned response. The trace
+// hooks a real transport would call are called too, so that attempts and
+// timeout phases are reported as usual.
+func (m *mockResponses) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+	if m.scrubbed {
+		key = scrubURL(key)
+	}
+
+	response, ok := m.next(key)
+	if !ok {
+		return nil, fmt.Errorf("no mock response for %s", redactURL(req.URL.String()))
+	}
+
+	trace := httptrace.ContextClientTrace(req.Context())
+	if trace != nil && trace.WroteRequest != nil {
+		trace.WroteRequest(httptrace.WroteRequestInfo{})
+	}
+
+	if err := m.wait(req.Context(), response); err != nil {
+		return nil, err
+	}
+	if response.failure != "" {
+		return nil, errors.New(response.failure)
+	}
+
+	header := make(http.Header, len(response.headers))
+	for name, value := range response.headers {
+		header.Set(name, value)
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", response.statusCode, http.StatusText(response.statusCode)),
+		StatusCode:    response.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(response.body)),
+		ContentLength: int64(len(response.body)),
+		Request:       req,
+	}, nil
+}
+
+// lookupDNS answers a DNS resolver lookup with its canned record value.
+func (m *mockResponses) lookupDNS(ctx context.Context, entry resolverEntry) (string, error) {
+	answer, reason, err := m.lookupAnswer(ctx, entry.name)
+	if reason != "" {
+		return "", &net.DNSError{
+			Err:    reason,
+			Name:   entry.dnsName,
+			Server: entry.dnsServer,
+		}
+	}
+	return answer, err
+}
+
+// lookupAnswer serves a resolver that is not queried over HTTP, whose canned
+// body is the address itself. A canned failure is returned as reason, for the
+// caller to turn into the error its protocol would produce.
+func (m *mockResponses) lookupAnswer(ctx context.Context, key string) (answer, reason string, err error) {
+	response, ok := m.next(key)
+	if !ok {
+		return "", "", fmt.Errorf("no mock response for %s", key)
+	}
+
+	if err := m.wait(ctx, response); err != nil {
+		return "", "", err
+	}
+
+	if response.failure != "" {
+		return "", response.failure, nil
+	}
+	if response.statusCode != http.StatusOK {
+		return "", fmt.Sprintf("mocked failure with status code %d", response.statusCode), nil
+	}
+	return strings.TrimSpace(response.body), "", nil
+}
+
+// next returns the answer to serve for key.
+func (m *mockResponses) next(key string) (mockResponse, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	responses := m.responses[key]
+	if len(responses) == 0 {
+		return mockResponse{}, false
+	}
+
+	if m.served == nil {
+		m.served = make(map[string]int)
+	}
+	i := min(m.served[key], len(responses)-1)
+	m.served[key]++
+	return responses[i], true
+}
+
+// wait applies the artificial delay of response, giving up when ctx ends.
+func (m *mockResponses) wait(ctx context.Context, response mockResponse) error {
+	if response.delay <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(response.delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,168 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func configureMockedProvider(t *testing.T, mocks ...map[string]interface{}) *providerConfig {
+	t.Helper()
+
+	raw := make([]interface{}, len(mocks))
+	for i, mock := range mocks {
+		raw[i] = mock
+	}
+	return configureTestProvider(t, map[string]interface{}{"mock_responses": raw})
+}
+
+func TestMockResponsesCatalogName(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{
+		"resolver": "ipify",
+		"body":     `{"ip":"192.0.2.20"}`,
+		"headers":  map[string]interface{}{"Content-Type": "application/json"},
+	})
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":               "ipify",
+		"expected_content_types": []interface{}{"application/json"},
+	})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if d.Get("ipaddress") != "192.0.2.20" {
+		t.Errorf("Expected the mocked address, got %v", d.Get("ipaddress"))
+	}
+	if d.Get("attempts") != 1 {
+		t.Errorf("Expected 1 attempt, got %v", d.Get("attempts"))
+	}
+}
+
+func TestMockResponsesFallback(t *testing.T) {
+	config := configureMockedProvider(t,
+		map[string]interface{}{"resolver": "aws", "status_code": http.StatusServiceUnavailable},
+		map[string]interface{}{"resolver": "icanhazip", "body": "192.0.2.21\n"},
+	)
+	config.defaults = lookupDefaults{resolvers: []string{"aws", "icanhazip"}}
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if d.Get("ipaddress") != "192.0.2.21" {
+		t.Errorf("Expected the second resolver's address, got %v", d.Get("ipaddress"))
+	}
+}
+
+func TestMockResponsesRedirect(t *testing.T) {
+	config := configureMockedProvider(t,
+		map[string]interface{}{
+			"resolver":    "https://old.example/ip",
+			"status_code": http.StatusMovedPermanently,
+			"headers":     map[string]interface{}{"Location": "https://new.example/ip"},
+		},
+		map[string]interface{}{"resolver": "https://new.example/ip", "body": "192.0.2.22"},
+	)
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "https://old.example/ip"})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if d.Get("resolver_used") != "https://new.example/ip" || d.Get("attempts") != 2 {
+		t.Errorf("Expected the redirect to be followed, got resolver_used %v after %v attempts",
+			d.Get("resolver_used"), d.Get("attempts"))
+	}
+}
+
+func TestMockResponsesDelay(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{
+		"resolver": "aws",
+		"body":     "192.0.2.23",
+		"delay_ms": 500,
+	})
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":       "aws",
+		"client_timeout": 50,
+	})
+	err := dataSourceRead(context.Background(), d, config)
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Errorf("Expected a timeout, got: %v", err)
+	}
+}
+
+func TestMockResponsesDNS(t *testing.T) {
+	config := configureMockedProvider(t,
+		map[string]interface{}{"resolver": "opendns-dns", "body": "192.0.2.24"},
+		map[string]interface{}{"resolver": "google-dns", "status_code": http.StatusServiceUnavailable},
+	)
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "opendns-dns"})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "192.0.2.24" {
+		t.Errorf("Expected the mocked DNS answer, got %v", d.Get("ipaddress"))
+	}
+
+	d = schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "google-dns"})
+	err := dataSourceRead(context.Background(), d, config)
+
+	var dnsErr *DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Errorf("Expected a DNS error, got: %v", err)
+	}
+}
+
+func TestMockResponsesUnmatchedResolver(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{"resolver": "aws", "body": "192.0.2.25"})
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "https://unmocked.example/"})
+	err := dataSourceRead(context.Background(), d, config)
+	if err == nil || !strings.Contains(err.Error(), "no mock response for https://unmocked.example/") {
+		t.Errorf("Expected unmocked resolver to fail, got: %v", err)
+	}
+}
+
+func TestMockResponsesDuplicate(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"mock_responses": []interface{}{
+			map[string]interface{}{"resolver": "aws"},
+			map[string]interface{}{"resolver": "https://checkip.amazonaws.com/"},
+		},
+	})
+
+	if _, diags := providerConfigure(context.Background(), d); !diags.HasError() {
+		t.Error("Expected duplicate mock responses to be rejected")
+	}
+}
+
+func TestMockResponsesIgnoreSourceInterface(t *testing.T) {
+	config := configureMockedProvider(t,
+		map[string]interface{}{"resolver": "aws", "body": "192.0.2.23"},
+		map[string]interface{}{"resolver": "opendns-dns", "body": "192.0.2.24"},
+	)
+
+	// The interface only exists on the hosts the module is deployed to
+	for resolver, want := range map[string]string{"aws": "192.0.2.23", "opendns-dns": "192.0.2.24"} {
+		d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+			"resolver":         resolver,
+			"source_interface": "eth-missing9",
+		})
+		if err := dataSourceRead(context.Background(), d, config); err != nil {
+			t.Fatalf("Unexpected error for %s: %v", resolver, err)
+		}
+		if d.Get("ipaddress") != want {
+			t.Errorf("Expected %s from %s, got %v", want, resolver, d.Get("ipaddress"))
+		}
+	}
+}
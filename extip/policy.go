@@ -0,0 +1,109 @@
+package extip
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// resolverPolicy restricts which resolver endpoints lookups may contact. It
+// is configured once on the provider and checked before every request.
+type resolverPolicy struct {
+	allowedHosts []string
+	deniedHosts  []string
+	requireHTTPS bool
+}
+
+// PolicyError is returned when a lookup would contact a resolver that the
+// provider policy does not permit. Setting names the provider argument that
+// blocked it.
+type PolicyError struct {
+	Resolver string
+	Setting  string
+	Reason   string
+}
+
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("resolver %s blocked by provider policy: %s", e.Resolver, e.Reason)
+}
+
+// checkURL verifies that a request to rawURL is permitted.
+func (p *resolverPolicy) checkURL(rawURL string) error {
+	if p == nil {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		// Malformed URLs fail when the request is built
+		return nil
+	}
+	return p.check(redactURL(rawURL), u.Scheme, u.Hostname())
+}
+
+// check verifies that a request over scheme to host is permitted. resolver is
+// only used to describe the endpoint in errors.
+func (p *resolverPolicy) check(resolver, scheme, host string) error {
+	if p == nil {
+		return nil
+	}
+
+	if p.requireHTTPS && !strings.EqualFold(scheme, "https") {
+		return &PolicyError{
+			Resolver: resolver,
+			Setting:  "require_https",
+			Reason:   fmt.Sprintf("require_https is set and the resolver uses %s", scheme),
+		}
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if pattern, ok := matchHost(host, p.deniedHosts); ok {
+		return &PolicyError{
+			Resolver: resolver,
+			Setting:  "denied_resolver_hosts",
+			Reason:   fmt.Sprintf("host %q matches denied_resolver_hosts entry %q", host, pattern),
+		}
+	}
+
+	if len(p.allowedHosts) > 0 {
+		if _, ok := matchHost(host, p.allowedHosts); !ok {
+			return &PolicyError{
+				Resolver: resolver,
+				Setting:  "allowed_resolver_hosts",
+				Reason:   fmt.Sprintf("host %q does not match any allowed_resolver_hosts entry", host),
+			}
+		}
+	}
+
+	return nil
+}
+
+// matchHost returns the first pattern matching host. Patterns are host names
+// or IP addresses where * matches any run of characters.
+func matchHost(host string, patterns []string) (string, bool) {
+	for _, pattern := range patterns {
+		if ok, err := path.Match(strings.ToLower(pattern), host); err == nil && ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// validateHostPattern checks a single allowed or denied host pattern.
+func validateHostPattern(i interface{}, k string) ([]string, []error) {
+	pattern, ok := i.(string)
+	if !ok {
+		return nil, []error{fmt.Errorf("expected type of %s to be string", k)}
+	}
+
+	if pattern == "" || (strings.ContainsAny(pattern, "/:") && net.ParseIP(pattern) == nil) {
+		return nil, []error{fmt.Errorf("expected %s to be a host name or IP address pattern, got %q", k, pattern)}
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, []error{fmt.Errorf("invalid pattern %q in %s: %s", pattern, k, err)}
+	}
+	return nil, nil
+}
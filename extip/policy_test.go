@@ -0,0 +1,163 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestResolverPolicyCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  *resolverPolicy
+		url     string
+		setting string
+	}{
+		{"nil policy", nil, "http://anything.example/", ""},
+		{"empty policy", &resolverPolicy{}, "http://anything.example/", ""},
+		{"require https allows https", &resolverPolicy{requireHTTPS: true}, "https://checkip.amazonaws.com/", ""},
+		{"require https blocks http", &resolverPolicy{requireHTTPS: true}, "http://checkip.amazonaws.com/", "require_https"},
+		{"allowed exact", &resolverPolicy{allowedHosts: []string{"checkip.amazonaws.com"}}, "https://checkip.amazonaws.com/", ""},
+		{"allowed wildcard", &resolverPolicy{allowedHosts: []string{"*.amazonaws.com"}}, "https://checkip.amazonaws.com/", ""},
+		{"allowed case insensitive", &resolverPolicy{allowedHosts: []string{"*.AmazonAWS.com"}}, "https://CHECKIP.amazonaws.com/", ""},
+		{"not allowed", &resolverPolicy{allowedHosts: []string{"*.amazonaws.com"}}, "https://api.ipify.org/", "allowed_resolver_hosts"},
+		{"denied", &resolverPolicy{deniedHosts: []string{"*.ipify.org"}}, "https://api.ipify.org/", "denied_resolver_hosts"},
+		{"denied wins over allowed", &resolverPolicy{allowedHosts: []string{"*"}, deniedHosts: []string{"api.ipify.org"}}, "https://api.ipify.org/", "denied_resolver_hosts"},
+		{"ip address", &resolverPolicy{allowedHosts: []string{"127.0.0.*"}}, "http://127.0.0.1:8080/", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.checkURL(tt.url)
+			if tt.setting == "" {
+				if err != nil {
+					t.Errorf("Expected %s to be allowed, got: %v", tt.url, err)
+				}
+				return
+			}
+
+			var policyErr *PolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Expected policy error, got: %v", err)
+			}
+			if policyErr.Setting != tt.setting {
+				t.Errorf("Expected %s to block, got: %s", tt.setting, policyErr.Setting)
+			}
+		})
+	}
+}
+
+func TestLookupExternalIPPolicyBlocksBeforeRequest(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	policy := &resolverPolicy{requireHTTPS: true}
+
+	if _, err := lookupExternalIP(context.Background(), server.URL, lookupOptions{policy: policy}); err == nil {
+		t.Error("Expected plain HTTP resolver to be blocked")
+	}
+
+	if _, err := lookupExternalIP(context.Background(), "opendns-dns", lookupOptions{policy: policy}); err == nil {
+		t.Error("Expected DNS resolver to be blocked when HTTPS is required")
+	}
+
+	denyAWS := &resolverPolicy{deniedHosts: []string{"*.amazonaws.com"}}
+	if _, err := lookupExternalIP(context.Background(), "aws", lookupOptions{policy: denyAWS}); err == nil {
+		t.Error("Expected catalog resolver on a denied host to be blocked")
+	}
+
+	if atomic.LoadInt32(&hits) != 0 {
+		t.Errorf("Expected no request to reach the resolver, got %d", hits)
+	}
+}
+
+func TestLookupExternalIPPolicyAppliesToRedirects(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		t.Error("Expected redirect target not to be contacted")
+	}))
+	defer target.Close()
+
+	redirector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1), http.StatusFound)
+	}))
+	defer redirector.Close()
+
+	policy := &resolverPolicy{allowedHosts: []string{"127.0.0.1"}}
+	_, err := lookupExternalIP(context.Background(), redirector.URL, lookupOptions{
+		maxRedirects:            10,
+		allowCrossHostRedirects: true,
+		policy:                  policy,
+	})
+
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Setting != "allowed_resolver_hosts" {
+		t.Errorf("Expected redirect to be blocked by allowed_resolver_hosts, got: %v", err)
+	}
+}
+
+func TestProviderConfigurePolicy(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{
+		"allowed_resolver_hosts": []interface{}{"*.amazonaws.com"},
+		"denied_resolver_hosts":  []interface{}{"evil.example"},
+		"require_https":          true,
+	})
+
+	meta, diags := providerConfigure(context.Background(), d)
+	if diags.HasError() {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+
+	policy := meta.(*providerConfig).policy
+	if !policy.requireHTTPS || len(policy.allowedHosts) != 1 || policy.deniedHosts[0] != "evil.example" {
+		t.Errorf("Unexpected policy: %+v", policy)
+	}
+}
+
+func TestDataSourceReadContextPolicyDiagnostic(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver": "http://checkip.amazonaws.com/",
+	})
+
+	meta := &providerConfig{transports: fallbackTransports, policy: &resolverPolicy{requireHTTPS: true}}
+	diags := dataSourceReadContext(context.Background(), d, meta)
+	if len(diags) != 1 {
+		t.Fatalf("Expected one diagnostic, got: %v", diags)
+	}
+
+	if diags[0].Summary != "Resolver blocked by provider policy" {
+		t.Errorf("Unexpected summary: %q", diags[0].Summary)
+	}
+
+	if !diags[0].AttributePath.Equals(cty.GetAttrPath("resolver")) {
+		t.Errorf("Expected resolver attribute path, got: %#v", diags[0].AttributePath)
+	}
+
+	if !strings.Contains(diags[0].Detail, "require_https") {
+		t.Errorf("Expected detail to name the setting, got: %q", diags[0].Detail)
+	}
+}
+
+func TestValidateHostPattern(t *testing.T) {
+	for _, valid := range []string{"example.com", "*.example.com", "10.0.0.*", "::1"} {
+		if _, errs := validateHostPattern(valid, "allowed_resolver_hosts"); len(errs) != 0 {
+			t.Errorf("Expected %q to be valid, got: %v", valid, errs)
+		}
+	}
+
+	for _, invalid := range []string{"", "https://example.com", "example.com:443", "[a-"} {
+		if _, errs := validateHostPattern(invalid, "allowed_resolver_hosts"); len(errs) == 0 {
+			t.Errorf("Expected %q to be invalid", invalid)
+		}
+	}
+}
@@ -2,18 +2,247 @@
 package extip
 
 import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
 )
 
+// providerConfig is the provider-level state shared by all data sources.
+type providerConfig struct {
+	transports *transportPool
+	policy     *resolverPolicy
+	defaults   lookupDefaults
+
+	// overrideIPAddress answers every lookup without network access when set.
+	overrideIPAddress string
+
+	// mocks serves canned resolver answers instead of the network when set.
+	mocks *mockResponses
+
+	// recorder writes resolver traffic to the record file when set.
+	recorder *trafficRecorder
+}
+
 // Provider returns a terraform.ResourceProvider.
 func Provider() *schema.Provider {
 	return &schema.Provider{
-		Schema: map[string]*schema.Schema{},
+		Schema: map[string]*schema.Schema{
+			"allowed_resolver_hosts": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Host names or IP addresses that lookups may contact, where * matches any characters (for example *.amazonaws.com)\nIf not set, any host not denied is allowed",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateHostPattern,
+				},
+			},
+			"denied_resolver_hosts": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Host names or IP addresses that lookups must never contact, where * matches any characters. Takes precedence over allowed_resolver_hosts",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateHostPattern,
+				},
+			},
+			"record_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(envRecordFile, nil),
+				Description: "A JSON file that every resolver request and response is written to, with credentials scrubbed. It is replaced on each run and can be replayed with replay_file. Responses with bodies over 1 MiB fail rather than being recorded in part\nCan also be set with the EXTIP_RECORD_FILE environment variable",
+			},
+			"replay_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(envReplayFile, nil),
+				Description: "A file written by record_file to serve lookups from instead of the network. Answers for the same resolver are replayed in recorded order\nCan also be set with the EXTIP_REPLAY_FILE environment variable",
+			},
+			"require_https": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(envRequireHTTPS, false),
+				Description: "Only allow lookups over HTTPS. Plain HTTP and non-HTTP resolvers, such as DNS ones, are rejected\nCan also be set with the EXTIP_REQUIRE_HTTPS environment variable",
+			},
+			"resolver": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc(envResolver, nil),
+				Description:  "The URL or built-in resolver name used by data sources that do not set resolver\nCan also be set with the EXTIP_RESOLVER environment variable",
+				ValidateFunc: validateResolver,
+			},
+			"resolvers": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Resolvers tried in order, after resolver, by data sources that do not set resolver. The first successful answer is used\nCan also be set with the EXTIP_RESOLVERS environment variable, as a comma-separated list",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateResolver,
+				},
+			},
+			"client_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc(envClientTimeout, 0),
+				Description:  "The time to wait for a response in ms, used by data sources that do not set client_timeout. 0 leaves the data source value in place\nCan also be set with the EXTIP_CLIENT_TIMEOUT environment variable",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+			"mock_responses": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Canned resolver answers served instead of network requests, for testing modules. When set, every lookup is answered from this list and resolvers without an entry fail",
+				Elem:        mockResponseSchema(),
+			},
+			"override_ipaddress": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc(envOverrideIPAddress, nil),
+				Description:  "An address returned by every extip data source without contacting any resolver, for offline and hermetic runs. A warning is shown whenever it is used\nCan also be set with the EXTIP_OVERRIDE_IPADDRESS environment variable",
+				ValidateFunc: validation.IsIPAddress,
+			},
+			"proxy": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc(envProxy, nil),
+				Description:  "The URL of an http, https or socks5 proxy that HTTP lookups go through\nCan also be set with the EXTIP_PROXY environment variable. If not set, lookups connect directly",
+				ValidateFunc: validation.IsURLWithScheme([]string{"http", "https", "socks5"}),
+			},
+			"validate_ip": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc(envValidateIP, false),
+				Description: "Validate that responses are valid ip addresses in data sources that do not set validate_ip\nCan also be set with the EXTIP_VALIDATE_IP environment variable",
+			},
+		},
 
 		DataSourcesMap: map[string]*schema.Resource{
-			"extip": dataSource(),
+			"extip":                  dataSource(),
+			"extip_resolvers":        dataSourceResolvers(),
+			"extip_egress_addresses": dataSourceEgressAddresses(),
+			"extip_local_interfaces": dataSourceLocalInterfaces(),
+			"extip_route_source":     dataSourceRouteSource(),
 		},
 
 		ResourcesMap: map[string]*schema.Resource{},
+
+		ConfigureContextFunc: providerConfigure,
+	}
+}
+
+func providerConfigure(ctx context.Context, d *schema.ResourceData) (interface{}, diag.Diagnostics) {
+	policy, err := expandResolverPolicy(d)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+
+	defaults, err := expandLookupDefaults(d)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+
+	override, ok := d.Get("override_ipaddress").(string)
+	if !ok {
+		return nil, diag.Errorf("override_ipaddress is not a string")
+	}
+
+	mocks, err := expandMockResponses(d)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+
+	recorder, replay, err := expandTrafficFiles(d, mocks != nil)
+	if err != nil {
+		return nil, diag.FromErr(err)
+	}
+	if replay != nil {
+		mocks = replay
+	}
+
+	config := &providerConfig{
+		transports:        newTransportPool(defaultTransportPoolSize, defaultTransportIdleTimeout),
+		policy:            policy,
+		defaults:          defaults,
+		overrideIPAddress: override,
+		mocks:             mocks,
+		recorder:          recorder,
+	}
+
+	// Release pooled connections once Terraform stops the provider
+	if stopCtx, ok := ctx.Value(schema.StopContextKey).(context.Context); ok {
+		go func() {
+			<-stopCtx.Done()
+			config.transports.close()
+		}()
+	}
+
+	return config, nil
+}
+
+// expandResolverPolicy builds the resolver policy from the provider arguments.
+func expandResolverPolicy(d *schema.ResourceData) (*resolverPolicy, error) {
+	allowed, ok := d.Get("allowed_resolver_hosts").([]interface{})
+	if !ok {
+		return nil, errors.New("allowed_resolver_hosts is not a list")
+	}
+
+	denied, ok := d.Get("denied_resolver_hosts").([]interface{})
+	if !ok {
+		return nil, errors.New("denied_resolver_hosts is not a list")
+	}
+
+	requireHTTPS, ok := d.Get("require_https").(bool)
+	if !ok {
+		return nil, errors.New("require_https is not a bool")
+	}
+
+	return &resolverPolicy{
+		allowedHosts: expandStringList(allowed),
+		deniedHosts:  expandStringList(denied),
+		requireHTTPS: requireHTTPS,
+	}, nil
+}
+
+// expandTrafficFiles sets up recording to record_file and replaying from
+// replay_file. Replaying serves canned answers, so it cannot be combined with
+// recording or with mock_responses.
+func expandTrafficFiles(d *schema.ResourceData, mocked bool) (*trafficRecorder, *mockResponses, error) {
+	recordFile, ok := d.Get("record_file").(string)
+	if !ok {
+		return nil, nil, errors.New("record_file is not a string")
+	}
+
+	replayFile, ok := d.Get("replay_file").(string)
+	if !ok {
+		return nil, nil, errors.New("replay_file is not a string")
+	}
+
+	if replayFile != "" {
+		if recordFile != "" {
+			return nil, nil, errors.New("record_file and replay_file cannot be used together")
+		}
+		if mocked {
+			return nil, nil, errors.New("mock_responses and replay_file cannot be used together")
+		}
+
+		replay, err := loadReplayFile(replayFile)
+		return nil, replay, err
+	}
+
+	if recordFile != "" {
+		recorder, err := newTrafficRecorder(recordFile)
+		return recorder, nil, err
+	}
+
+	return nil, nil, nil
+}
+
+// providerConfigFrom returns the configured provider state, or a default one
+// backed by the fallback transport pool when the provider was not configured.
+func providerConfigFrom(meta interface{}) *providerConfig {
+	if config, ok := meta.(*providerConfig); ok && config != nil {
+		return config
 	}
+	return &providerConfig{transports: fallbackTransports}
 }
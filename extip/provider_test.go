@@ -1,8 +1,11 @@
 package extip
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/terraform-plugin-sdk/v2/diag"
 	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
 )
 
@@ -15,3 +18,81 @@ func TestProvider(t *testing.T) {
 		t.Fatalf("err: %s", err)
 	}
 }
+
+func TestProviderConfigureClosesTransportsOnStop(t *testing.T) {
+	stopCtx, stop := context.WithCancel(context.Background())
+	ctx := context.WithValue(context.Background(), schema.StopContextKey, stopCtx)
+
+	meta, diags := providerConfigure(ctx, schema.TestResourceDataRaw(t, Provider().Schema, map[string]interface{}{}))
+	if diags.HasError() {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+
+	config, ok := meta.(*providerConfig)
+	if !ok {
+		t.Fatalf("Expected *providerConfig, got %T", meta)
+	}
+
+	if config.transports == fallbackTransports {
+		t.Error("Expected a provider-owned transport pool")
+	}
+
+	config.transports.get(transportConfig{})
+	if config.transports.size() != 1 {
+		t.Fatalf("Expected 1 cached transport, got %d", config.transports.size())
+	}
+
+	stop()
+
+	deadline := time.Now().Add(time.Second)
+	for config.transports.size() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected transport pool to be emptied when the provider stops")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProviderConfigFromNilMeta(t *testing.T) {
+	if providerConfigFrom(nil).transports != fallbackTransports {
+		t.Error("Expected fallback transport pool without a configured provider")
+	}
+}
+
+func TestProviderOverrideIPAddressFromEnvironment(t *testing.T) {
+	t.Setenv(envOverrideIPAddress, "192.0.2.10")
+
+	config := configureTestProvider(t, map[string]interface{}{})
+	if config.overrideIPAddress != "192.0.2.10" {
+		t.Errorf("Expected override from the environment, got %q", config.overrideIPAddress)
+	}
+}
+
+func TestDataSourceReadContextOverride(t *testing.T) {
+	meta := &providerConfig{transports: fallbackTransports, overrideIPAddress: "192.0.2.10"}
+
+	// The resolver must never be contacted while the override is set
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver": "http://resolver.invalid/",
+	})
+
+	diags := dataSourceReadContext(context.Background(), d, meta)
+	if len(diags) != 1 || diags[0].Severity != diag.Warning {
+		t.Fatalf("Expected a single warning, got: %v", diags)
+	}
+
+	expected := map[string]interface{}{
+		"ipaddress": "192.0.2.10",
+		"succeeded": true,
+		"source":    sourceOverride,
+	}
+	for key, value := range expected {
+		if d.Get(key) != value {
+			t.Errorf("Expected %s to be %v, got %v", key, value, d.Get(key))
+		}
+	}
+
+	if d.Id() == "" {
+		t.Error("Expected ID to be set")
+	}
+}
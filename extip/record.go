@@ -0,0 +1,247 @@
+package extip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// recordFileVersion is bumped whenever the record file format changes.
+	recordFileVersion = 1
+
+	// recordBodyLimit bounds how much of a response body is recorded.
+	recordBodyLimit = 1024 * 1024
+
+	// scrubbedValue replaces credentials in recorded traffic.
+	scrubbedValue = "REDACTED"
+)
+
+// sensitiveQueryParams are query parameters whose values are scrubbed from
+// recorded resolver URLs.
+var sensitiveQueryParams = []string{"access_token", "api_key", "apikey", "key", "password", "secret", "signature", "token"}
+
+// sensitiveHeaders are headers left out of recorded traffic.
+var sensitiveHeaders = []string{"Authorization", "Cookie", "Proxy-Authorization", "Set-Cookie"}
+
+// trafficFile is the JSON document written by record_file and read by replay_file.
+type trafficFile struct {
+	Version   int               `json:"version"`
+	Exchanges []trafficExchange `json:"exchanges"`
+}
+
+// trafficExchange is one resolver request and what came back.
+type trafficExchange struct {
+	Protocol string           `json:"protocol"`
+	Resolver string           `json:"resolver"`
+	Request  *trafficRequest  `json:"request,omitempty"`
+	Response *trafficResponse `json:"response,omitempty"`
+	Error    string           `json:"error,omitempty"`
+}
+
+type trafficRequest struct {
+	Method  string            `json:"method"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+type trafficResponse struct {
+	StatusCode int               `json:"status_code,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Body       string            `json:"body"`
+}
+
+// trafficRecorder writes every resolver exchange to a JSON file. The file is
+// rewritten after each exchange, so it is complete whenever the run stops.
+type trafficRecorder struct {
+	mu   sync.Mutex
+	path string
+	file trafficFile
+}
+
+// newTrafficRecorder creates the record file at path, replacing any previous
+// recording, so that an unwritable path fails when the provider is configured.
+func newTrafficRecorder(path string) (*trafficRecorder, error) {
+	r := &trafficRecorder{
+		path: path,
+		file: trafficFile{Version: recordFileVersion, Exchanges: []trafficExchange{}},
+	}
+	if err := r.write(); err != nil {
+		return nil, fmt.Errorf("error creating record_file: %w", err)
+	}
+	return r, nil
+}
+
+// wrap returns a transport that records the exchanges made through next.
+func (r *trafficRecorder) wrap(next http.RoundTripper) http.RoundTripper {
+	return &recordingTransport{next: next, recorder: r}
+}
+
+// recordAnswer records a lookup that is not made over HTTP, such as a DNS
+// query, and the address it returned.
+func (r *trafficRecorder) recordAnswer(ctx context.Context, protocol, resolver, answer string, err error) {
+	exchange := trafficExchange{Protocol: protocol, Resolver: resolver}
+	if err != nil {
+		exchange.Error = err.Error()
+	} else {
+		exchange.Response = &trafficResponse{Body: answer}
+	}
+	r.add(ctx, exchange)
+}
+
+func (r *trafficRecorder) add(ctx context.Context, exchange trafficExchange) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.file.Exchanges = append(r.file.Exchanges, exchange)
+	if err := r.write(); err != nil {
+		logWarn(ctx, "Could not update record file", map[string]interface{}{
+			logFieldError: err.Error(),
+		})
+	}
+}
+
+func (r *trafficRecorder) write() error {
+	data, err := json.MarshalIndent(r.file, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data, 0o600)
+}
+
+// recordingTransport passes requests on to the next transport and records them.
+type recordingTransport struct {
+	next     http.RoundTripper
+	recorder *trafficRecorder
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	exchange := trafficExchange{
+		Protocol: req.URL.Scheme,
+		Resolver: scrubURL(req.URL.String()),
+		Request:  &trafficRequest{Method: req.Method, Headers: scrubHeaders(req.Header)},
+	}
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		exchange.Error = err.Error()
+		t.recorder.add(req.Context(), exchange)
+		return nil, err
+	}
+
+	body, err := readRecordedBody(resp.Body)
+	if err != nil {
+		exchange.Error = err.Error()
+		t.recorder.add(req.Context(), exchange)
+		return nil, err
+	}
+
+	exchange.Response = &trafficResponse{
+		StatusCode: resp.StatusCode,
+		Headers:    scrubHeaders(resp.Header),
+		Body:       string(body),
+	}
+	t.recorder.add(req.Context(), exchange)
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}
+
+// readRecordedBody reads and closes a response body. A body larger than
+// recordBodyLimit is refused rather than truncated, so the lookup never sees
+// less than the resolver sent.
+func readRecordedBody(body io.ReadCloser) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(body, recordBodyLimit+1))
+	closeErr := body.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && len(data) > recordBodyLimit {
+		err = &ResponseError{
+			Setting: "resolver",
+			Reason:  fmt.Sprintf("response body exceeds the %d bytes the provider record_file can hold", recordBodyLimit),
+		}
+	}
+	return data, err
+}
+
+// loadReplayFile reads a record file and serves its exchanges as canned
+// answers. Exchanges of the same resolver are replayed in recorded order.
+func loadReplayFile(path string) (*mockResponses, error) {
+	data, err := os.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, fmt.Errorf("error reading replay_file: %w", err)
+	}
+
+	var file trafficFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("error parsing replay_file: %w", err)
+	}
+	if file.Version != recordFileVersion {
+		return nil, fmt.Errorf("replay_file has version %d, expected %d", file.Version, recordFileVersion)
+	}
+
+	mocks := &mockResponses{responses: make(map[string][]mockResponse), scrubbed: true}
+	for _, exchange := range file.Exchanges {
+		response := mockResponse{statusCode: http.StatusOK, failure: exchange.Error}
+		if exchange.Response != nil {
+			response.body = exchange.Response.Body
+			response.headers = exchange.Response.Headers
+			if exchange.Response.StatusCode != 0 {
+				response.statusCode = exchange.Response.StatusCode
+			}
+		}
+		mocks.responses[exchange.Resolver] = append(mocks.responses[exchange.Resolver], response)
+	}
+
+	return mocks, nil
+}
+
+// scrubURL removes credentials from a resolver URL: the user info and the
+// values of sensitive query parameters.
+func scrubURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.User = nil
+
+	query := u.Query()
+	scrubbed := false
+	for name := range query {
+		for _, sensitive := range sensitiveQueryParams {
+			if strings.EqualFold(name, sensitive) {
+				query.Set(name, scrubbedValue)
+				scrubbed = true
+			}
+		}
+	}
+	if scrubbed {
+		u.RawQuery = query.Encode()
+	}
+
+	return u.String()
+}
+
+// scrubHeaders flattens headers for recording, leaving out credentials.
+func scrubHeaders(header http.Header) map[string]string {
+	if len(header) == 0 {
+		return nil
+	}
+
+	flat := make(map[string]string, len(header))
+	for name := range header {
+		flat[name] = header.Get(name)
+	}
+	for _, sensitive := range sensitiveHeaders {
+		delete(flat, http.CanonicalHeaderKey(sensitive))
+	}
+	return flat
+}
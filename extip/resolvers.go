@@ -0,0 +1,329 @@
+package extip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+)
+
+// Resolver protocols.
+const (
+	protocolHTTPS = "https"
+	protocolDNS   = "dns"
+)
+
+// Address families a resolver answers with.
+const (
+	familyIPv4 = "ipv4"
+	familyIPv6 = "ipv6"
+	familyAny  = "any"
+)
+
+// Response formats understood by the HTTP strategy.
+const (
+	formatText  = "text"
+	formatJSON  = "json"
+	formatTrace = "trace"
+)
+
+// resolverEntry describes a well-known resolver that can be referenced by name.
+type resolverEntry struct {
+	name          string
+	description   string
+	protocol      string
+	addressFamily string
+
+	// url and format are used by HTTPS resolvers.
+	url    string
+	format string
+
+	// dnsServer, dnsName and dnsRecord are used by DNS resolvers: the address
+	// is read from the dnsRecord record of dnsName as served by dnsServer.
+	dnsServer string
+	dnsName   string
+	dnsRecord string
+}
+
+// target returns the URL or DNS query the entry resolves against.
+func (e resolverEntry) target() string {
+	if e.protocol == protocolDNS {
+		return fmt.Sprintf("dns://%s/%s?type=%s", e.dnsServer, e.dnsName, e.dnsRecord)
+	}
+	return e.url
+}
+
+// resolverCatalog lists the built-in resolvers by name.
+var resolverCatalog = map[string]resolverEntry{
+	"aws": {
+		description: "Amazon Web Services checkip", protocol: protocolHTTPS, addressFamily: familyIPv4,
+		url: "https://checkip.amazonaws.com/", format: formatText,
+	},
+	"ipify": {
+		description: "ipify IPv4 API", protocol: protocolHTTPS, addressFamily: familyIPv4,
+		url: "https://api.ipify.org/?format=json", format: formatJSON,
+	},
+	"ipify-v6": {
+		description: "ipify IPv6 API", protocol: protocolHTTPS, addressFamily: familyIPv6,
+		url: "https://api6.ipify.org/?format=json", format: formatJSON,
+	},
+	"ipify-any": {
+		description: "ipify API, IPv6 when available", protocol: protocolHTTPS, addressFamily: familyAny,
+		url: "https://api64.ipify.org/?format=json", format: formatJSON,
+	},
+	"icanhazip": {
+		description: "icanhazip, IPv6 when available", protocol: protocolHTTPS, addressFamily: familyAny,
+		url: "https://icanhazip.com/", format: formatText,
+	},
+	"icanhazip-v4": {
+		description: "icanhazip IPv4", protocol: protocolHTTPS, addressFamily: familyIPv4,
+		url: "https://ipv4.icanhazip.com/", format: formatText,
+	},
+	"icanhazip-v6": {
+		description: "icanhazip IPv6", protocol: protocolHTTPS, addressFamily: familyIPv6,
+		url: "https://ipv6.icanhazip.com/", format: formatText,
+	},
+	"cloudflare": {
+		description: "Cloudflare trace endpoint", protocol: protocolHTTPS, addressFamily: familyAny,
+		url: "https://www.cloudflare.com/cdn-cgi/trace", format: formatTrace,
+	},
+	"cloudflare-v4": {
+		description: "Cloudflare trace endpoint over 1.1.1.1", protocol: protocolHTTPS, addressFamily: familyIPv4,
+		url: "https://1.1.1.1/cdn-cgi/trace", format: formatTrace,
+	},
+	"ifconfig-me": {
+		description: "ifconfig.me", protocol: protocolHTTPS, addressFamily: familyAny,
+		url: "https://ifconfig.me/ip", format: formatText,
+	},
+	"opendns-dns": {
+		description: "OpenDNS myip lookup over DNS", protocol: protocolDNS, addressFamily: familyIPv4,
+		dnsServer: "resolver1.opendns.com:53", dnsName: "myip.opendns.com", dnsRecord: "A",
+	},
+	"aws-imds": {
+		description: "AWS EC2 instance metadata (IMDSv2), public IPv4 or else IPv6", protocol: protocolMetadata, addressFamily: familyAny,
+		url: "http://169.254.169.254",
+	},
+	"azure-imds": {
+		description: "Azure instance metadata service, public IPv4 or else IPv6", protocol: protocolMetadata, addressFamily: familyAny,
+		url: "http://169.254.169.254",
+	},
+	"gcp-metadata": {
+		description: "Google Compute Engine metadata server, external IPv4 or else IPv6", protocol: protocolMetadata, addressFamily: familyAny,
+		url: "http://metadata.google.internal",
+	},
+	"google-dns": {
+		description: "Google myaddr lookup over DNS", protocol: protocolDNS, addressFamily: familyAny,
+		dnsServer: "ns1.google.com:53", dnsName: "o-o.myaddr.l.google.com", dnsRecord: "TXT",
+	},
+}
+
+// resolverNames returns the catalog names in sorted order.
+func resolverNames() []string {
+	names := make([]string, 0, len(resolverCatalog))
+	for name := range resolverCatalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// catalogEntry returns the named resolver, if name is a catalog name.
+func catalogEntry(name string) (resolverEntry, bool) {
+	entry, ok := resolverCatalog[strings.ToLower(name)]
+	if ok {
+		entry.name = strings.ToLower(name)
+	}
+	return entry, ok
+}
+
+// hostResolver is a resolver URL of the form scheme://HOST[:PORT].
+type hostResolver struct {
+	scheme string
+	// host is empty when the resolver names none.
+	host string
+	// port is 0 when the resolver names none.
+	port int
+}
+
+// parseHostResolver reads resolver as scheme://HOST[:PORT] when its scheme is
+// one of schemes, which ok reports. err is set when a matching resolver also
+// has a path, query or credentials, or an invalid port.
+func parseHostResolver(resolver string, schemes ...string) (r hostResolver, ok bool, err error) {
+	u, err := url.Parse(resolver)
+	if err != nil {
+		return hostResolver{}, false, nil
+	}
+
+	r = hostResolver{scheme: strings.ToLower(u.Scheme), host: u.Hostname()}
+	if !slices.Contains(schemes, r.scheme) {
+		return hostResolver{}, false, nil
+	}
+
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return r, true, fmt.Errorf("%s:// resolvers take no path, query or credentials, got %s", r.scheme, resolver)
+	}
+	if raw := u.Port(); raw != "" {
+		r.port, err = strconv.Atoi(raw)
+		if err != nil || r.port < 1 || r.port > 65535 {
+			return r, true, fmt.Errorf("invalid port in %s", resolver)
+		}
+	}
+
+	return r, true, nil
+}
+
+// resolverScheme is the lookup strategy of resolver URLs with a scheme other
+// than http and https.
+type resolverScheme struct {
+	// validate checks the parts of a resolver URL after its scheme.
+	validate func(resolver string) error
+	// lookup resolves the external IP with a resolver validate accepted.
+	lookup func(ctx context.Context, resolver string, opts lookupOptions) (*lookupResult, error)
+}
+
+var resolverSchemes = map[string]resolverScheme{
+	protocolNATPMP: {validate: validateWith(parseGatewayResolver), lookup: lookupGateway},
+	protocolPCP:    {validate: validateWith(parseGatewayResolver), lookup: lookupGateway},
+	protocolUPnP:   {validate: validateWith(parseUPnPResolver), lookup: lookupUPnP},
+	protocolTCP:    {validate: validateWith(parseSocketResolver), lookup: lookupSocket},
+	protocolUDP:    {validate: validateWith(parseSocketResolver), lookup: lookupSocket},
+}
+
+// validateWith adapts a resolver parser to resolverScheme.validate.
+func validateWith[T any](parse func(string) (T, bool, error)) func(string) error {
+	return func(resolver string) error {
+		_, _, err := parse(resolver)
+		return err
+	}
+}
+
+// resolverSchemeOf returns the strategy of resolver's URL scheme, if it has one.
+func resolverSchemeOf(resolver string) (resolverScheme, bool) {
+	scheme, _, ok := strings.Cut(resolver, "://")
+	if !ok {
+		return resolverScheme{}, false
+	}
+	strategy, ok := resolverSchemes[strings.ToLower(scheme)]
+	return strategy, ok
+}
+
+// validateResolver accepts catalog names, URLs of the resolver schemes and
+// HTTP(S) URLs.
+func validateResolver(i interface{}, k string) ([]string, []error) {
+	if name, ok := i.(string); ok {
+		if _, ok := catalogEntry(name); ok {
+			return nil, nil
+		}
+		if scheme, ok := resolverSchemeOf(name); ok {
+			if err := scheme.validate(name); err != nil {
+				return nil, []error{fmt.Errorf("%s: %w", k, err)}
+			}
+			return nil, nil
+		}
+	}
+	return validation.IsURLWithHTTPorHTTPS(i, k)
+}
+
+// lookupExternalIP resolves the external IP with the strategy matching
+// resolver, which is either a catalog name, a URL of one of the resolver
+// schemes or an HTTP(S) URL.
+func lookupExternalIP(ctx context.Context, resolver string, opts lookupOptions) (*lookupResult, error) {
+	if scheme, ok := resolverSchemeOf(resolver); ok {
+		if err := scheme.validate(resolver); err != nil {
+			return &lookupResult{resolverUsed: resolver}, &ResponseError{Resolver: resolver, Setting: "resolver", Reason: err.Error()}
+		}
+		return scheme.lookup(ctx, resolver, opts)
+	}
+
+	if entry, ok := catalogEntry(resolver); ok {
+		return lookupCatalogEntry(ctx, entry, opts)
+	}
+
+	if err := opts.policy.checkURL(resolver); err != nil {
+		return &lookupResult{resolverUsed: redactURL(resolver)}, err
+	}
+	return getExternalIPFrom(ctx, resolver, opts)
+}
+
+// lookupCatalogEntry resolves the external IP with a built-in resolver.
+func lookupCatalogEntry(ctx context.Context, entry resolverEntry, opts lookupOptions) (*lookupResult, error) {
+	switch entry.protocol {
+	case protocolDNS:
+		host, _, _ := net.SplitHostPort(entry.dnsServer)
+		if err := opts.policy.check(entry.target(), protocolDNS, host); err != nil {
+			return &lookupResult{resolverUsed: entry.target()}, err
+		}
+		return getExternalIPFromDNS(ctx, entry, opts)
+	case protocolMetadata:
+		endpoint := metadataEndpoint(entry, opts)
+		if err := opts.policy.checkURL(endpoint); err != nil {
+			return &lookupResult{resolverUsed: redactURL(endpoint)}, err
+		}
+		return getExternalIPFromMetadata(ctx, entry, opts)
+	default:
+		if err := opts.policy.checkURL(entry.url); err != nil {
+			return &lookupResult{resolverUsed: entry.url}, err
+		}
+		opts.network = networkForFamily(entry.addressFamily)
+		opts.parse = parserForFormat(entry.format)
+		return getExternalIPFrom(ctx, entry.url, opts)
+	}
+}
+
+// networkForFamily returns the dial network restricting connections to family.
+func networkForFamily(family string) string {
+	switch family {
+	case familyIPv4:
+		return "tcp4"
+	case familyIPv6:
+		return "tcp6"
+	default:
+		return ""
+	}
+}
+
+// responseParser extracts the address from a resolver response body.
+type responseParser func(body []byte) (string, error)
+
+func parserForFormat(format string) responseParser {
+	switch format {
+	case formatJSON:
+		return parseJSONResponse
+	case formatTrace:
+		return parseTraceResponse
+	default:
+		return nil
+	}
+}
+
+// parseJSONResponse reads the "ip" field of a JSON object.
+func parseJSONResponse(body []byte) (string, error) {
+	var payload struct {
+		IP string `json:"ip"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", fmt.Errorf("could not parse JSON response: %w", err)
+	}
+	if payload.IP == "" {
+		return "", errors.New(`JSON response has no "ip" field`)
+	}
+	return payload.IP, nil
+}
+
+// parseTraceResponse reads the ip= line of a key=value trace response.
+func parseTraceResponse(body []byte) (string, error) {
+	for _, line := range strings.Split(string(body), "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "ip="); ok {
+			return value, nil
+		}
+	}
+	return "", errors.New("trace response has no ip= line")
+}
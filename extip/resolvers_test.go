@@ -0,0 +1,183 @@
+package extip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestResolverCatalogEntries(t *testing.T) {
+	for _, name := range resolverNames() {
+		entry, ok := catalogEntry(name)
+		if !ok || entry.name != name {
+			t.Errorf("Expected catalog entry for %q", name)
+			continue
+		}
+
+		switch entry.protocol {
+		case protocolHTTPS:
+			u, err := url.Parse(entry.url)
+			if err != nil || u.Scheme != "https" || u.Host == "" {
+				t.Errorf("Expected %q to have an HTTPS URL, got %q", name, entry.url)
+			}
+		case protocolDNS:
+			if entry.dnsServer == "" || entry.dnsName == "" || entry.dnsRecord == "" {
+				t.Errorf("Expected %q to have a complete DNS query, got %+v", name, entry)
+			}
+		case protocolMetadata:
+			u, err := url.Parse(entry.url)
+			if err != nil || u.Scheme != "http" || u.Host == "" {
+				t.Errorf("Expected %q to have a metadata endpoint, got %q", name, entry.url)
+			}
+		default:
+			t.Errorf("Unexpected protocol %q for %q", entry.protocol, name)
+		}
+
+		switch entry.addressFamily {
+		case familyIPv4, familyIPv6, familyAny:
+		default:
+			t.Errorf("Unexpected address family %q for %q", entry.addressFamily, name)
+		}
+	}
+
+	for _, required := range []string{"aws", "ipify", "icanhazip", "cloudflare", "opendns-dns"} {
+		if _, ok := catalogEntry(required); !ok {
+			t.Errorf("Expected catalog to contain %q", required)
+		}
+	}
+}
+
+func TestValidateResolver(t *testing.T) {
+	tests := []struct {
+		value   string
+		wantErr string
+	}{
+		{"aws", ""},
+		{"IPIFY", ""},
+		{"https://example.com/", ""},
+		{"not-a-valid-url", `expected "resolver" to have a host, got not-a-valid-url`},
+		{"ftp://example.com/", `expected "resolver" to have a url with schema of: "http,https", got ftp://example.com/`},
+	}
+
+	for _, tt := range tests {
+		_, errs := validateResolver(tt.value, "resolver")
+		if tt.wantErr == "" {
+			if len(errs) != 0 {
+				t.Errorf("Expected %q to be valid, got: %v", tt.value, errs)
+			}
+			continue
+		}
+
+		if len(errs) != 1 || errs[0].Error() != tt.wantErr {
+			t.Errorf("Expected %q to fail with %q, got: %v", tt.value, tt.wantErr, errs)
+		}
+	}
+}
+
+func TestResponseParsers(t *testing.T) {
+	tests := []struct {
+		name    string
+		parser  responseParser
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{"json", parseJSONResponse, `{"ip":"192.0.2.10"}`, "192.0.2.10", false},
+		{"json without ip", parseJSONResponse, `{"address":"192.0.2.10"}`, "", true},
+		{"invalid json", parseJSONResponse, `192.0.2.10`, "", true},
+		{"trace", parseTraceResponse, "fl=1\nh=www.cloudflare.com\nip=2001:db8::1\nts=1\n", "2001:db8::1", false},
+		{"trace without ip", parseTraceResponse, "fl=1\nh=www.cloudflare.com\n", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parser([]byte(tt.body))
+			if tt.wantErr != (err != nil) {
+				t.Fatalf("Expected error %v, got: %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestLookupExternalIPCatalogHTTPS(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("fl=1\nip=" + testIP + "\n"))
+	}))
+	defer server.Close()
+
+	resolverCatalog["test-trace"] = resolverEntry{
+		protocol: protocolHTTPS, addressFamily: familyIPv4, url: server.URL, format: formatTrace,
+	}
+	defer delete(resolverCatalog, "test-trace")
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":    "test-trace",
+		"validate_ip": true,
+	})
+
+	if err := dataSourceRead(context.Background(), d, nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if d.Get("ipaddress").(string) != testIP {
+		t.Errorf("Expected %s, got: %s", testIP, d.Get("ipaddress"))
+	}
+
+	if d.Get("resolver_used").(string) != server.URL {
+		t.Errorf("Expected catalog URL in resolver_used, got: %s", d.Get("resolver_used"))
+	}
+}
+
+func TestLookupExternalIPCatalogParseError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("not json"))
+	}))
+	defer server.Close()
+
+	resolverCatalog["test-json"] = resolverEntry{
+		protocol: protocolHTTPS, addressFamily: familyAny, url: server.URL, format: formatJSON,
+	}
+	defer delete(resolverCatalog, "test-json")
+
+	_, err := lookupExternalIP(context.Background(), "test-json", lookupOptions{})
+	if err == nil || !strings.Contains(err.Error(), "could not parse JSON response") {
+		t.Errorf("Expected JSON parse error, got: %v", err)
+	}
+}
+
+func TestDataSourceResolversRead(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, dataSourceResolvers().Schema, map[string]interface{}{})
+
+	if diags := dataSourceResolversRead(context.Background(), d, nil); diags.HasError() {
+		t.Fatalf("Unexpected diagnostics: %v", diags)
+	}
+
+	resolvers := d.Get("resolvers").([]interface{})
+	if len(resolvers) != len(resolverCatalog) {
+		t.Fatalf("Expected %d resolvers, got %d", len(resolverCatalog), len(resolvers))
+	}
+
+	first := resolvers[0].(map[string]interface{})
+	if first["name"] != "aws" || first["protocol"] != protocolHTTPS || first["url"] != "https://checkip.amazonaws.com/" {
+		t.Errorf("Unexpected first resolver: %v", first)
+	}
+
+	for _, r := range resolvers {
+		entry := r.(map[string]interface{})
+		if entry["name"] == "opendns-dns" && entry["url"] != "dns://resolver1.opendns.com:53/myip.opendns.com?type=A" {
+			t.Errorf("Unexpected opendns-dns query: %v", entry["url"])
+		}
+	}
+
+	if d.Id() == "" {
+		t.Error("Expected ID to be set")
+	}
+}
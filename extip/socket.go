@@ -0,0 +1,261 @@
+package extip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line protocol resolver schemes.
+const (
+	protocolTCP = "tcp"
+	protocolUDP = "udp"
+)
+
+// maxUDPPayloadBytes is the largest datagram a UDP resolver can answer with.
+const maxUDPPayloadBytes = 65507
+
+// socketTarget is the service a tcp:// or udp:// resolver names.
+type socketTarget struct {
+	protocol string
+	host     string
+	port     int
+}
+
+func (t socketTarget) address() string {
+	return net.JoinHostPort(t.host, strconv.Itoa(t.port))
+}
+
+// parseSocketResolver reads a tcp://HOST:PORT or udp://HOST:PORT resolver.
+// ok is false for other schemes, and err is set when the scheme matches but
+// the rest of the resolver is invalid.
+func parseSocketResolver(resolver string) (target socketTarget, ok bool, err error) {
+	r, ok, err := parseHostResolver(resolver, protocolTCP, protocolUDP)
+	if !ok || err != nil {
+		return socketTarget{protocol: r.scheme}, ok, err
+	}
+
+	target = socketTarget{protocol: r.scheme, host: r.host, port: r.port}
+	if target.host == "" || target.port == 0 {
+		return target, true, fmt.Errorf("expected %s://HOST:PORT, got %s", r.scheme, resolver)
+	}
+
+	return target, true, nil
+}
+
+// lookupSocket is the strategy of tcp:// and udp:// resolvers.
+func lookupSocket(ctx context.Context, resolver string, opts lookupOptions) (*lookupResult, error) {
+	target, _, _ := parseSocketResolver(resolver)
+	if opts.tunnel != nil && target.protocol == protocolUDP {
+		return &lookupResult{resolverUsed: resolver}, errNotOverSSH(protocolUDP)
+	}
+	if err := opts.policy.check(resolver, target.protocol, target.host); err != nil {
+		return &lookupResult{resolverUsed: resolver}, err
+	}
+	return getExternalIPFromSocket(ctx, resolver, target, opts)
+}
+
+// getExternalIPFromSocket reads the first line a tcp:// or udp:// resolver
+// answers with, after sending the configured payload.
+func getExternalIPFromSocket(ctx context.Context, resolver string, target socketTarget, opts lookupOptions) (result *lookupResult, err error) {
+	result = &lookupResult{resolverUsed: resolver}
+	start := time.Now()
+
+	logDebug(ctx, "Querying resolver", map[string]interface{}{
+		logFieldResolver: result.resolverUsed,
+		logFieldStrategy: target.protocol,
+		logFieldAttempt:  1,
+	})
+
+	defer func() {
+		result.latency = time.Since(start)
+		logLookupOutcome(ctx, result, err)
+	}()
+
+	if opts.clientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msToDuration(opts.clientTimeout))
+		defer cancel()
+	}
+
+	var answer string
+	if opts.mocks != nil {
+		var reason string
+		result.attempts = 1
+		answer, reason, err = opts.mocks.lookupAnswer(ctx, resolver)
+		if reason != "" {
+			err = errors.New(reason)
+		}
+	} else {
+		answer, err = querySocket(ctx, target, opts, result)
+	}
+	if opts.recorder != nil {
+		opts.recorder.recordAnswer(ctx, target.protocol, resolver, answer, err)
+	}
+	if err != nil {
+		return result, classifySocketError(resolver, target.protocol, err)
+	}
+
+	result.ip = strings.TrimSpace(answer)
+	return result, nil
+}
+
+// querySocket connects to target, sends the payload and reads one line.
+func querySocket(ctx context.Context, target socketTarget, opts lookupOptions, result *lookupResult) (string, error) {
+	result.attempts = 1
+	conn, err := dialSocket(ctx, target, opts)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	result.remoteAddr = hostFromAddr(conn.RemoteAddr())
+	result.localAddr = hostFromAddr(conn.LocalAddr())
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return "", err
+		}
+	}
+
+	// A datagram has to be sent for a UDP service to answer, even an empty one
+	if opts.payload != "" || target.protocol == protocolUDP {
+		if _, err := conn.Write([]byte(opts.payload)); err != nil {
+			return "", err
+		}
+	}
+
+	if target.protocol == protocolUDP {
+		return readDatagramLine(conn, opts.maxResponseBytes)
+	}
+	return readLine(conn, opts.maxResponseBytes)
+}
+
+// dialSocket connects to target from the configured source, or through the
+// SSH tunnel when there is one.
+func dialSocket(ctx context.Context, target socketTarget, opts lookupOptions) (net.Conn, error) {
+	if ip := net.ParseIP(target.host); ip != nil {
+		opts.network = "tcp6"
+		if ip.To4() != nil {
+			opts.network = "tcp4"
+		}
+	}
+	opts, err := opts.bindSource()
+	if err != nil {
+		return nil, err
+	}
+
+	network := opts.network
+	if network == "" {
+		network = "tcp"
+	}
+	if target.protocol == protocolUDP {
+		network = strings.Replace(network, "tcp", "udp", 1)
+	}
+
+	var conn net.Conn
+	if opts.tunnel != nil {
+		conn, err = opts.tunnel.dialContext(opts.network)(ctx, network, target.address())
+	} else {
+		dialer := &net.Dialer{
+			Timeout:   msToDuration(opts.dialTimeout),
+			LocalAddr: localAddr(network, opts.sourceAddress),
+		}
+		conn, err = dialer.DialContext(ctx, network, target.address())
+	}
+	if err != nil && isTimeout(err) {
+		setting := "client_timeout"
+		if opts.timeoutConfigured("dial_timeout") {
+			setting = "dial_timeout"
+		}
+		return nil, &TimeoutError{Phase: phaseConnect, Setting: setting, Err: err}
+	}
+	return conn, err
+}
+
+// readLine reads up to the first newline, or the end of the stream, within
+// maxBytes (0 means the default).
+func readLine(r io.Reader, maxBytes int) (string, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxResponseBytes
+	}
+
+	reader := bufio.NewReader(io.LimitReader(r, int64(maxBytes)+1))
+	line, err := reader.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		if errors.Is(err, io.EOF) {
+			return "", errors.New("the connection closed without an answer")
+		}
+		return "", err
+	}
+	if len(line) > maxBytes {
+		return "", &ResponseError{
+			Setting: "max_response_bytes",
+			Reason:  fmt.Sprintf("answer line exceeds max_response_bytes (%d bytes)", maxBytes),
+		}
+	}
+
+	return line, nil
+}
+
+// readDatagramLine reads one datagram of at most maxBytes (0 means the
+// default) and returns its first line.
+func readDatagramLine(conn net.Conn, maxBytes int) (string, error) {
+	if maxBytes <= 0 {
+		maxBytes = defaultMaxResponseBytes
+	}
+
+	buf := make([]byte, min(maxBytes+1, maxUDPPayloadBytes))
+	n, err := conn.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	if n > maxBytes {
+		return "", &ResponseError{
+			Setting: "max_response_bytes",
+			Reason:  fmt.Sprintf("answer datagram exceeds max_response_bytes (%d bytes)", maxBytes),
+		}
+	}
+
+	line, _, _ := bytes.Cut(buf[:n], []byte("\n"))
+	return string(line), nil
+}
+
+// classifySocketError wraps an error from a tcp:// or udp:// lookup in the
+// matching error type.
+func classifySocketError(resolver, protocol string, err error) error {
+	var (
+		timeoutErr  *TimeoutError
+		responseErr *ResponseError
+		policyErr   *PolicyError
+		sourceErr   *SourceError
+		dnsErr      *net.DNSError
+		opErr       *net.OpError
+	)
+
+	switch {
+	case errors.As(err, &timeoutErr):
+		timeoutErr.Resolver = resolver
+		return err
+	case errors.As(err, &responseErr):
+		responseErr.Resolver = resolver
+		return err
+	case errors.As(err, &policyErr), errors.As(err, &sourceErr):
+		return err
+	case isTimeout(err):
+		return &TimeoutError{Resolver: resolver, Phase: fmt.Sprintf("reading the %s answer", strings.ToUpper(protocol)), Setting: "client_timeout", Err: err}
+	case errors.As(err, &dnsErr):
+		return &DNSError{Resolver: resolver, Err: err}
+	case errors.As(err, &opErr):
+		return &ConnectionError{Resolver: resolver, Err: err}
+	default:
+		return &ResponseError{Resolver: resolver, Setting: "resolver", Reason: err.Error()}
+	}
+}
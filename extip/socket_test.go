@@ -0,0 +1,318 @@
+package extip
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-cty/cty"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+// startTCPLineStandIn answers each connection with answer. When expect is set,
+// it first waits for a line and closes the connection unless it matches.
+func startTCPLineStandIn(t *testing.T, expect, answer string) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				if expect != "" {
+					line, err := bufio.NewReader(conn).ReadString('\n')
+					if err != nil || line != expect {
+						return
+					}
+				}
+				_, _ = conn.Write([]byte(answer))
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+// startUDPLineStandIn answers each datagram carrying expect with answer.
+func startUDPLineStandIn(t *testing.T, expect, answer string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == expect {
+				_, _ = conn.WriteTo([]byte(answer), addr)
+			}
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestParseSocketResolver(t *testing.T) {
+	tests := []struct {
+		resolver string
+		ok       bool
+		target   string
+		wantErr  bool
+	}{
+		{"tcp://whoami.example.com:4444", true, "whoami.example.com:4444", false},
+		{"UDP://192.0.2.1:5555/", true, "192.0.2.1:5555", false},
+		{"udp://[2001:db8::1]:5555", true, "[2001:db8::1]:5555", false},
+		{"tcp://whoami.example.com", true, "", true},
+		{"tcp://:4444", true, "", true},
+		{"tcp://whoami.example.com:0", true, "", true},
+		{"udp://whoami.example.com:5555/ip", true, "", true},
+		{"upnp://", false, "", false},
+		{"https://checkip.amazonaws.com/", false, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resolver, func(t *testing.T) {
+			target, ok, err := parseSocketResolver(tt.resolver)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok %v, got %v", tt.ok, ok)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got: %v", tt.wantErr, err)
+			}
+			if err == nil && ok && target.address() != tt.target {
+				t.Errorf("Expected %q, got %q", tt.target, target.address())
+			}
+		})
+	}
+}
+
+func TestGetExternalIPFromTCP(t *testing.T) {
+	addr := startTCPLineStandIn(t, "", "203.0.113.60\r\nbye\n")
+
+	result, err := lookupExternalIP(context.Background(), "tcp://"+addr, lookupOptions{clientTimeout: 1000})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.60" {
+		t.Errorf("Expected only the first line, got: %q", result.ip)
+	}
+	if result.remoteAddr != testIP || result.localAddr != testIP {
+		t.Errorf("Expected loopback addresses, got remote %s and local %s", result.remoteAddr, result.localAddr)
+	}
+}
+
+func TestGetExternalIPFromTCPWithoutNewline(t *testing.T) {
+	addr := startTCPLineStandIn(t, "", "203.0.113.61")
+
+	result, err := lookupExternalIP(context.Background(), "tcp://"+addr, lookupOptions{clientTimeout: 1000})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.61" {
+		t.Errorf("Expected 203.0.113.61, got: %q", result.ip)
+	}
+}
+
+func TestGetExternalIPFromTCPPayload(t *testing.T) {
+	addr := startTCPLineStandIn(t, "ip\n", "203.0.113.62\n")
+
+	result, err := lookupExternalIP(context.Background(), "tcp://"+addr, lookupOptions{clientTimeout: 1000, payload: "ip\n"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.62" {
+		t.Errorf("Expected 203.0.113.62, got: %q", result.ip)
+	}
+
+	// Without the payload the stand-in keeps waiting for a line
+	_, err = lookupExternalIP(context.Background(), "tcp://"+addr, lookupOptions{clientTimeout: 200})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != "reading the TCP answer" {
+		t.Errorf("Expected a read timeout, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromTCPClosed(t *testing.T) {
+	addr := startTCPLineStandIn(t, "", "")
+
+	_, err := lookupExternalIP(context.Background(), "tcp://"+addr, lookupOptions{clientTimeout: 1000})
+
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || !strings.Contains(err.Error(), "closed without an answer") {
+		t.Errorf("Expected a response error, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromTCPMaxResponseBytes(t *testing.T) {
+	addr := startTCPLineStandIn(t, "", strings.Repeat("x", 64)+"\n")
+
+	_, err := lookupExternalIP(context.Background(), "tcp://"+addr, lookupOptions{clientTimeout: 1000, maxResponseBytes: 16})
+
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Setting != "max_response_bytes" {
+		t.Errorf("Expected max_response_bytes to reject the answer, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromTCPRefused(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	_, err = lookupExternalIP(context.Background(), "tcp://"+addr, lookupOptions{clientTimeout: 1000})
+
+	var connErr *ConnectionError
+	if !errors.As(err, &connErr) {
+		t.Errorf("Expected a connection error, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromUDP(t *testing.T) {
+	addr := startUDPLineStandIn(t, "", "203.0.113.63\nextra\n")
+
+	result, err := lookupExternalIP(context.Background(), "udp://"+addr, lookupOptions{clientTimeout: 1000})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.63" {
+		t.Errorf("Expected only the first line, got: %q", result.ip)
+	}
+}
+
+func TestGetExternalIPFromUDPPayload(t *testing.T) {
+	addr := startUDPLineStandIn(t, "whoami", "203.0.113.64")
+
+	result, err := lookupExternalIP(context.Background(), "udp://"+addr, lookupOptions{clientTimeout: 1000, payload: "whoami"})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.64" {
+		t.Errorf("Expected 203.0.113.64, got: %q", result.ip)
+	}
+
+	_, err = lookupExternalIP(context.Background(), "udp://"+addr, lookupOptions{clientTimeout: 200})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) || timeoutErr.Phase != "reading the UDP answer" || timeoutErr.Setting != "client_timeout" {
+		t.Errorf("Expected a read timeout bounded by client_timeout, got: %v", err)
+	}
+}
+
+func TestSocketResolverValidatesIP(t *testing.T) {
+	addr := startTCPLineStandIn(t, "", "Your address is 203.0.113.65\n")
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":    "tcp://" + addr,
+		"validate_ip": true,
+	})
+	err := dataSourceRead(context.Background(), d, nil)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Errorf("Expected the answer to fail validation, got: %v", err)
+	}
+}
+
+func TestSocketResolverPayloadFromSchema(t *testing.T) {
+	addr := startUDPLineStandIn(t, "\n", "203.0.113.66\n")
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":       "udp://" + addr,
+		"socket_payload": "\n",
+	})
+	if err := dataSourceRead(context.Background(), d, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "203.0.113.66" {
+		t.Errorf("Expected 203.0.113.66, got %v", d.Get("ipaddress"))
+	}
+}
+
+func TestSocketResolverMocked(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{"resolver": "tcp://whoami.example.com:4444", "body": "192.0.2.60\n"})
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "tcp://whoami.example.com:4444"})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "192.0.2.60" {
+		t.Errorf("Expected the mocked address, got %v", d.Get("ipaddress"))
+	}
+}
+
+func TestSocketResolverPolicy(t *testing.T) {
+	opts := lookupOptions{policy: &resolverPolicy{requireHTTPS: true}}
+
+	_, err := lookupExternalIP(context.Background(), "tcp://whoami.example.com:4444", opts)
+
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Setting != "require_https" {
+		t.Errorf("Expected require_https to refuse the resolver, got: %v", err)
+	}
+}
+
+func TestSocketResolverUDPNotOverSSH(t *testing.T) {
+	_, err := lookupExternalIP(context.Background(), "udp://192.0.2.1:5555", lookupOptions{tunnel: &sshTunnel{}})
+
+	var sourceErr *SourceError
+	if !errors.As(err, &sourceErr) || sourceErr.Setting != "ssh" {
+		t.Errorf("Expected a source error for ssh, got: %v", err)
+	}
+}
+
+func TestSocketResolverConnectTimeoutSetting(t *testing.T) {
+	addr := startTCPLineStandIn(t, "", "203.0.113.67\n")
+
+	// The lookup deadline has passed before the connection is made
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	tests := []struct {
+		name    string
+		opts    lookupOptions
+		setting string
+	}{
+		{"client_timeout only", lookupOptions{clientTimeout: 1000}, "client_timeout"},
+		{"dial_timeout", lookupOptions{clientTimeout: 1000, dialTimeout: 500}, "dial_timeout"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := lookupExternalIP(expired, "tcp://"+addr, tt.opts)
+
+			var timeoutErr *TimeoutError
+			if !errors.As(err, &timeoutErr) || timeoutErr.Phase != phaseConnect || timeoutErr.Setting != tt.setting {
+				t.Fatalf("Expected a connect timeout bounded by %s, got: %v", tt.setting, err)
+			}
+
+			configured := func(setting string) bool { return setting == "client_timeout" || tt.opts.timeoutConfigured(setting) }
+			d := errorDiagnostic(err, configured)
+			if !d.AttributePath.Equals(cty.GetAttrPath(tt.setting)) || !strings.Contains(d.Detail, "(see "+tt.setting+")") {
+				t.Errorf("Expected the diagnostic to point at %s, got %#v", tt.setting, d)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package extip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// bindSource resolves the local address lookups are bound to. A source
+// interface is replaced by its address of the family the resolver needs, and
+// connections are restricted to the family of the source address. Mocked
+// lookups never reach the network, so they are left unbound.
+func (o lookupOptions) bindSource() (lookupOptions, error) {
+	if o.mocks != nil {
+		return o, nil
+	}
+
+	if o.sourceInterface != "" {
+		ip, err := interfaceAddress(o.sourceInterface, o.network)
+		if err != nil {
+			return o, err
+		}
+		o.sourceAddress = ip.String()
+	}
+
+	if o.sourceAddress == "" {
+		return o, nil
+	}
+
+	ip := net.ParseIP(o.sourceAddress)
+	if ip == nil {
+		return o, &SourceError{Setting: "source_address", Reason: fmt.Sprintf("%q is not an IP address", o.sourceAddress)}
+	}
+
+	network := "tcp6"
+	if ip.To4() != nil {
+		network = "tcp4"
+	}
+	if o.network != "" && o.network != network {
+		return o, &SourceError{
+			Setting: "source_address",
+			Reason:  fmt.Sprintf("source address %s cannot reach a resolver that needs %s", ip, familyForNetwork(o.network)),
+		}
+	}
+	o.network = network
+
+	return o, nil
+}
+
+// interfaceAddress returns the first global unicast address of the named
+// interface usable with network. IPv4 is preferred when network allows both.
+func interfaceAddress(name, network string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, &SourceError{Setting: "source_interface", Reason: fmt.Sprintf("interface %q not found: %s", name, err)}
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, &SourceError{Setting: "source_interface", Reason: fmt.Sprintf("could not list addresses of interface %q: %s", name, err)}
+	}
+
+	if ip := firstAddress(addrs, network, false); ip != nil {
+		return ip, nil
+	}
+	return nil, &SourceError{
+		Setting: "source_interface",
+		Reason:  fmt.Sprintf("interface %q has no global unicast %s address", name, familyForNetwork(network)),
+	}
+}
+
+// firstAddress returns the first global unicast address in addrs usable with
+// network, or nil if there is none. Loopback addresses are only returned when
+// loopback is set, for a loopback interface chosen on purpose. IPv4 is
+// preferred when network allows both.
+func firstAddress(addrs []net.Addr, network string, loopback bool) net.IP {
+	var v4, v6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || !usableAddress(ipNet.IP, loopback) {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			if v4 == nil {
+				v4 = ipNet.IP
+			}
+		} else if v6 == nil {
+			v6 = ipNet.IP
+		}
+	}
+
+	switch {
+	case network != "tcp6" && v4 != nil:
+		return v4
+	case network != "tcp4" && v6 != nil:
+		return v6
+	default:
+		return nil
+	}
+}
+
+// usableAddress reports whether a lookup can be sent from ip. Link-local
+// addresses need a zone and cannot reach a resolver, and loopback addresses
+// only qualify when loopback is set.
+func usableAddress(ip net.IP, loopback bool) bool {
+	return ip.IsGlobalUnicast() || loopback && ip.IsLoopback()
+}
+
+// familyForNetwork names the address family of a dial network.
+func familyForNetwork(network string) string {
+	switch network {
+	case "tcp4":
+		return "IPv4"
+	case "tcp6":
+		return "IPv6"
+	default:
+		return "IPv4 or IPv6"
+	}
+}
+
+// localAddr returns the local address to bind a socket of network to, or nil
+// when lookups are not bound.
+func localAddr(network, source string) net.Addr {
+	ip := net.ParseIP(source)
+	if ip == nil {
+		return nil
+	}
+	if strings.HasPrefix(network, "udp") {
+		return &net.UDPAddr{IP: ip}
+	}
+	return &net.TCPAddr{IP: ip}
+}
@@ -0,0 +1,179 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func loopbackInterface(t *testing.T) net.Interface {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface
+		}
+	}
+	t.Skip("No loopback interface available")
+	return net.Interface{}
+}
+
+func TestGetExternalIPFromSourceAddress(t *testing.T) {
+	remote := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	// Every 127.0.0.0/8 address is local on Linux, but not on every platform
+	probe, err := net.ListenPacket("udp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("Cannot bind to 127.0.0.2: %v", err)
+	}
+	probe.Close()
+
+	_, err = getExternalIPFrom(context.Background(), server.URL, lookupOptions{sourceAddress: "127.0.0.2"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	host, _, _ := net.SplitHostPort(<-remote)
+	if host != "127.0.0.2" {
+		t.Errorf("Expected the request to come from 127.0.0.2, got %s", host)
+	}
+}
+
+// globalInterface returns an up interface with a global unicast IPv4 address
+// and that address.
+func globalInterface(t *testing.T) (net.Interface, net.IP) {
+	t.Helper()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		if ip := firstAddress(addrs, "tcp4", false); ip != nil {
+			return iface, ip
+		}
+	}
+	t.Skip("No interface with a global unicast IPv4 address available")
+	return net.Interface{}, nil
+}
+
+func TestDataSourceReadSourceInterface(t *testing.T) {
+	iface, ip := globalInterface(t)
+
+	listener, err := net.Listen("tcp4", net.JoinHostPort(ip.String(), "0"))
+	if err != nil {
+		t.Skipf("Cannot listen on %s: %v", ip, err)
+	}
+	remote := make(chan string, 1)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remote <- r.RemoteAddr
+		_, _ = w.Write([]byte(testIP))
+	}))
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver":         server.URL,
+		"source_interface": iface.Name,
+	})
+	if err := dataSourceRead(context.Background(), d, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	host, _, _ := net.SplitHostPort(<-remote)
+	if host != ip.String() {
+		t.Errorf("Expected the request to come from %s, got %s", ip, host)
+	}
+}
+
+func TestFirstAddress(t *testing.T) {
+	addrs := []net.Addr{
+		&net.IPNet{IP: net.ParseIP("127.0.0.1"), Mask: net.CIDRMask(8, 32)},
+		&net.IPNet{IP: net.ParseIP("169.254.1.1"), Mask: net.CIDRMask(16, 32)},
+		&net.IPNet{IP: net.ParseIP("::1"), Mask: net.CIDRMask(128, 128)},
+		&net.IPNet{IP: net.ParseIP("fe80::1"), Mask: net.CIDRMask(64, 128)},
+	}
+	for _, network := range []string{"", "tcp4", "tcp6"} {
+		if ip := firstAddress(addrs, network, false); ip != nil {
+			t.Errorf("Expected no usable %s address, got %s", familyForNetwork(network), ip)
+		}
+	}
+	if ip := firstAddress(addrs, "", true); !ip.Equal(net.ParseIP("127.0.0.1")) {
+		t.Errorf("Expected the loopback address when loopback is allowed, got %s", ip)
+	}
+
+	addrs = append(addrs,
+		&net.IPNet{IP: net.ParseIP("2001:db8::1"), Mask: net.CIDRMask(64, 128)},
+		&net.IPNet{IP: net.ParseIP("192.0.2.1"), Mask: net.CIDRMask(24, 32)},
+	)
+	tests := map[string]string{"": "192.0.2.1", "tcp4": "192.0.2.1", "tcp6": "2001:db8::1"}
+	for network, want := range tests {
+		if ip := firstAddress(addrs, network, false); ip.String() != want {
+			t.Errorf("Expected %s for %q, got %s", want, network, ip)
+		}
+	}
+}
+
+func TestBindSource(t *testing.T) {
+	opts, err := lookupOptions{sourceAddress: "192.0.2.1"}.bindSource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.network != "tcp4" {
+		t.Errorf("Expected an IPv4 source to restrict connections to tcp4, got %q", opts.network)
+	}
+
+	_, err = lookupOptions{sourceAddress: "192.0.2.1", network: "tcp6"}.bindSource()
+	var sourceErr *SourceError
+	if !errors.As(err, &sourceErr) || sourceErr.Setting != "source_address" {
+		t.Errorf("Expected a family mismatch error, got: %v", err)
+	}
+}
+
+func TestInterfaceAddressErrors(t *testing.T) {
+	var sourceErr *SourceError
+
+	_, err := interfaceAddress("extip-missing0", "")
+	if !errors.As(err, &sourceErr) || sourceErr.Setting != "source_interface" {
+		t.Errorf("Expected a missing interface error, got: %v", err)
+	}
+
+	// Loopback addresses cannot reach a resolver elsewhere
+	iface := loopbackInterface(t)
+	for _, network := range []string{"", "tcp4", "tcp6"} {
+		if _, err := interfaceAddress(iface.Name, network); !errors.As(err, &sourceErr) || sourceErr.Setting != "source_interface" {
+			t.Errorf("Expected %s to have no usable %s address, got: %v", iface.Name, familyForNetwork(network), err)
+		}
+	}
+}
+
+func TestErrorDiagnosticSourceError(t *testing.T) {
+	diagnostic := errorDiagnostic(&SourceError{Setting: "source_interface", Reason: `interface "eth9" has no IPv6 address`},
+		func(string) bool { return true })
+
+	if diagnostic.Summary != "Cannot send lookup from the requested source" {
+		t.Errorf("Unexpected summary: %q", diagnostic.Summary)
+	}
+}
@@ -0,0 +1,382 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/validation"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSH lookup methods.
+const (
+	sshMethodTunnel     = "tunnel"
+	sshMethodConnection = "ssh_connection"
+)
+
+const (
+	defaultSSHPort           = 22
+	defaultSSHConnectTimeout = 10000
+)
+
+func sshSchema() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"host": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "The host name or IP address of the remote host",
+				ValidateFunc: validation.StringIsNotWhiteSpace,
+			},
+			"port": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultSSHPort,
+				Description:  "The SSH port of the remote host\nIf not set, defaults to 22",
+				ValidateFunc: validation.IsPortNumber,
+			},
+			"user": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "The user to log in as",
+				ValidateFunc: validation.StringIsNotWhiteSpace,
+			},
+			"private_key": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Sensitive:     true,
+				Description:   "The contents of a PEM or OpenSSH private key to authenticate with",
+				ConflictsWith: []string{"ssh.0.private_key_file"},
+			},
+			"private_key_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "The path of a private key file to authenticate with, where ~ is the home directory",
+				ConflictsWith: []string{"ssh.0.private_key"},
+			},
+			"private_key_passphrase": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "The passphrase of an encrypted private key",
+			},
+			"agent": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Default:     true,
+				Description: "Also authenticate with the keys of the SSH agent at SSH_AUTH_SOCK, when it is set\nIf not set, defaults to true",
+			},
+			"known_hosts_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The known_hosts file the host key is verified against, where ~ is the home directory\nIf not set, defaults to ~/.ssh/known_hosts",
+			},
+			"host_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "The expected public host key in authorized_keys format, such as ssh-ed25519 AAAA..., used instead of known_hosts_file",
+			},
+			"method": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      sshMethodTunnel,
+				Description:  "How the address is found: tunnel sends the resolver lookup through the SSH connection, so it leaves from the remote host; ssh_connection reads the address the connection arrived on from SSH_CONNECTION on the remote host, which is only the egress address when the host is not behind NAT\nIf not set, defaults to tunnel",
+				ValidateFunc: validation.StringInSlice([]string{sshMethodTunnel, sshMethodConnection}, false),
+			},
+			"connect_timeout": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      defaultSSHConnectTimeout,
+				Description:  "The time to wait for the SSH connection and login in ms\nIf not set, defaults to 10000 (10 seconds). Setting to 0 means infinite (no timeout)",
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+		},
+	}
+}
+
+// sshConfig is the expanded ssh block.
+type sshConfig struct {
+	host                 string
+	port                 int
+	user                 string
+	privateKey           string
+	privateKeyFile       string
+	privateKeyPassphrase string
+	agent                bool
+	knownHostsFile       string
+	hostKey              string
+	method               string
+	connectTimeout       int
+}
+
+// address returns the host and port to connect to.
+func (c sshConfig) address() string {
+	return net.JoinHostPort(c.host, strconv.Itoa(c.port))
+}
+
+// String names the remote host in results and errors.
+func (c sshConfig) String() string {
+	return fmt.Sprintf("ssh://%s@%s", c.user, c.address())
+}
+
+// expandSSHConfig reads the ssh block. It returns nil when the block is not set.
+func expandSSHConfig(d *schema.ResourceData) (*sshConfig, error) {
+	raw, ok := d.Get("ssh").([]interface{})
+	if !ok {
+		return nil, errors.New("ssh is not a list")
+	}
+	if len(raw) == 0 || raw[0] == nil {
+		return nil, nil
+	}
+
+	block, ok := raw[0].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("ssh is not a block")
+	}
+
+	config := &sshConfig{}
+	config.host, _ = block["host"].(string)
+	config.port, _ = block["port"].(int)
+	config.user, _ = block["user"].(string)
+	config.privateKey, _ = block["private_key"].(string)
+	config.privateKeyFile, _ = block["private_key_file"].(string)
+	config.privateKeyPassphrase, _ = block["private_key_passphrase"].(string)
+	config.agent, _ = block["agent"].(bool)
+	config.knownHostsFile, _ = block["known_hosts_file"].(string)
+	config.hostKey, _ = block["host_key"].(string)
+	config.method, _ = block["method"].(string)
+	config.connectTimeout, _ = block["connect_timeout"].(int)
+
+	return config, nil
+}
+
+// sshTunnel is an SSH connection lookups are carried over, so that they leave
+// from the remote host.
+type sshTunnel struct {
+	config sshConfig
+	client *ssh.Client
+	// agentConn is the connection to the SSH agent, nil when it is not used.
+	agentConn net.Conn
+}
+
+// dialSSH connects and logs in to the remote host described by config.
+func dialSSH(ctx context.Context, config sshConfig) (*sshTunnel, error) {
+	tunnel := &sshTunnel{config: config}
+
+	hostKeyCallback, err := config.hostKeyCallback()
+	if err != nil {
+		return nil, &SSHError{Host: config.String(), Err: err}
+	}
+
+	auth, err := tunnel.authMethods()
+	if err != nil {
+		tunnel.close()
+		return nil, &SSHError{Host: config.String(), Err: err}
+	}
+
+	if config.connectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msToDuration(config.connectTimeout))
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", config.address())
+	if err != nil {
+		tunnel.close()
+		return nil, &SSHError{Host: config.String(), Err: err}
+	}
+
+	// The handshake does not watch ctx, so its deadline is set on the connection
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	clientConn, chans, reqs, err := ssh.NewClientConn(conn, config.address(), &ssh.ClientConfig{
+		User:            config.user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		_ = conn.Close()
+		tunnel.close()
+		return nil, &SSHError{Host: config.String(), Err: err}
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	tunnel.client = ssh.NewClient(clientConn, chans, reqs)
+	return tunnel, nil
+}
+
+// hostKeyCallback verifies the remote host against host_key, or else the
+// known_hosts file.
+func (c sshConfig) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if c.hostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(c.hostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host_key: %w", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	}
+
+	path := c.knownHostsFile
+	if path == "" {
+		path = "~/.ssh/known_hosts"
+	}
+	path, err := expandHome(path)
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read known_hosts_file %s: %w", path, err)
+	}
+	return callback, nil
+}
+
+// authMethods returns the configured private key, followed by the agent keys.
+func (t *sshTunnel) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	key := []byte(t.config.privateKey)
+	if t.config.privateKeyFile != "" {
+		path, err := expandHome(t.config.privateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if key, err = os.ReadFile(filepath.Clean(path)); err != nil {
+			return nil, fmt.Errorf("could not read private_key_file: %w", err)
+		}
+	}
+	if len(key) > 0 {
+		var (
+			signer ssh.Signer
+			err    error
+		)
+		if t.config.privateKeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(t.config.privateKeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the private key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if socket := os.Getenv("SSH_AUTH_SOCK"); t.config.agent && socket != "" {
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to the SSH agent: %w", err)
+		}
+		t.agentConn = conn
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if len(methods) == 0 {
+		return nil, errors.New("no credentials: set private_key or private_key_file, or run an SSH agent")
+	}
+	return methods, nil
+}
+
+// dialContext returns a dial function opening connections from the remote
+// host. Host names are resolved locally. network, when set, restricts the
+// address family as for direct connections.
+func (t *sshTunnel) dialContext(network string) func(context.Context, string, string) (net.Conn, error) {
+	if network == "" {
+		network = "tcp"
+	}
+	return func(ctx context.Context, _, addr string) (net.Conn, error) {
+		conn, err := t.client.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to %s through %s: %w", addr, t.config, err)
+		}
+		return conn, nil
+	}
+}
+
+// connectionAddress reads the address the SSH connection arrived on from the
+// SSH_CONNECTION variable of the remote shell.
+func (t *sshTunnel) connectionAddress(ctx context.Context) (result *lookupResult, err error) {
+	result = &lookupResult{
+		resolverUsed: t.config.String(),
+		remoteAddr:   hostFromAddr(t.client.RemoteAddr()),
+		attempts:     1,
+	}
+	start := time.Now()
+
+	logDebug(ctx, "Querying resolver", map[string]interface{}{
+		logFieldResolver: result.resolverUsed,
+		logFieldStrategy: sshMethodConnection,
+		logFieldAttempt:  1,
+	})
+
+	defer func() {
+		result.latency = time.Since(start)
+		logLookupOutcome(ctx, result, err)
+	}()
+
+	session, err := t.client.NewSession()
+	if err != nil {
+		return result, &SSHError{Host: t.config.String(), Err: err}
+	}
+	defer session.Close()
+
+	output, err := session.Output("echo $SSH_CONNECTION")
+	if err != nil {
+		return result, &SSHError{Host: t.config.String(), Err: fmt.Errorf("could not read SSH_CONNECTION: %w", err)}
+	}
+
+	// SSH_CONNECTION is "client-address client-port server-address server-port"
+	fields := strings.Fields(string(output))
+	if len(fields) != 4 || net.ParseIP(fields[2]) == nil {
+		return result, &ResponseError{
+			Resolver: result.resolverUsed,
+			Setting:  "ssh",
+			Reason:   fmt.Sprintf("unexpected SSH_CONNECTION on the remote host: %q", truncateForDiagnostic(strings.TrimSpace(string(output)))),
+		}
+	}
+
+	result.ip = fields[2]
+	return result, nil
+}
+
+// close ends the SSH connection and the agent connection.
+func (t *sshTunnel) close() {
+	if t.client != nil {
+		_ = t.client.Close()
+	}
+	if t.agentConn != nil {
+		_ = t.agentConn.Close()
+	}
+}
+
+// errNotOverSSH is returned for resolvers that talk UDP to the local
+// network, which direct-tcpip channels cannot carry.
+func errNotOverSSH(protocol string) error {
+	return &SourceError{Setting: "ssh", Reason: fmt.Sprintf("%s:// resolvers cannot be used through ssh", protocol)}
+}
+
+// expandHome replaces a leading ~ in path with the home directory.
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not expand %s: %w", path, err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
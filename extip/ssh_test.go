@@ -0,0 +1,320 @@
+package extip
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// sshStandIn is an in-process SSH server accepting one client key. It opens
+// direct-tcpip channels to their target and answers exec requests with
+// sshConnection.
+type sshStandIn struct {
+	addr          string
+	hostKey       ssh.PublicKey
+	clientKey     string
+	sshConnection string
+}
+
+func startSSHStandIn(t *testing.T) *sshStandIn {
+	t.Helper()
+	// An agent in the environment must not offer its keys to the stand-in
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	_, hostPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPrivate)
+	if err != nil {
+		t.Fatalf("Failed to create the host signer: %v", err)
+	}
+
+	clientPublic, clientPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate the client key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(clientPrivate, "")
+	if err != nil {
+		t.Fatalf("Failed to marshal the client key: %v", err)
+	}
+	authorized, err := ssh.NewPublicKey(clientPublic)
+	if err != nil {
+		t.Fatalf("Failed to create the client public key: %v", err)
+	}
+
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+
+	s := &sshStandIn{
+		addr:          listener.Addr().String(),
+		hostKey:       hostSigner.PublicKey(),
+		clientKey:     string(pem.EncodeToMemory(block)),
+		sshConnection: "127.0.0.1 50022 198.51.100.70 22",
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+
+	return s
+}
+
+func (s *sshStandIn) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "direct-tcpip":
+			go s.forward(newChannel)
+		case "session":
+			go s.session(newChannel)
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *sshStandIn) forward(newChannel ssh.NewChannel) {
+	var target struct {
+		Host       string
+		Port       uint32
+		OriginHost string
+		OriginPort uint32
+	}
+	if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+
+	upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+	if err != nil {
+		_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		_ = upstream.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	go func() {
+		_, _ = io.Copy(upstream, channel)
+		_ = upstream.Close()
+	}()
+	_, _ = io.Copy(channel, upstream)
+	_ = channel.Close()
+}
+
+func (s *sshStandIn) session(newChannel ssh.NewChannel) {
+	channel, reqs, err := newChannel.Accept()
+	if err != nil {
+		return
+	}
+	defer channel.Close()
+
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+		fmt.Fprintf(channel, "%s\n", s.sshConnection)
+		_, _ = channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+		return
+	}
+}
+
+// block returns an ssh block for the stand-in pinning its host key.
+func (s *sshStandIn) block(method string) map[string]interface{} {
+	host, port, _ := net.SplitHostPort(s.addr)
+	portNumber, _ := strconv.Atoi(port)
+	return map[string]interface{}{
+		"host":        host,
+		"port":        portNumber,
+		"user":        "terraform",
+		"private_key": s.clientKey,
+		"host_key":    string(ssh.MarshalAuthorizedKey(s.hostKey)),
+		"method":      method,
+	}
+}
+
+func TestSSHTunnelLookup(t *testing.T) {
+	standIn := startSSHStandIn(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The stand-in forwards from its own address, as the remote host would
+		_, _ = io.WriteString(w, "198.51.100.71")
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver": server.URL,
+		"ssh":      []interface{}{standIn.block(sshMethodTunnel)},
+	})
+	if err := dataSourceRead(context.Background(), d, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if d.Get("ipaddress") != "198.51.100.71" {
+		t.Errorf("Expected 198.51.100.71, got %v", d.Get("ipaddress"))
+	}
+	if d.Get("resolver_used") != server.URL {
+		t.Errorf("Expected the resolver to be used, got %v", d.Get("resolver_used"))
+	}
+}
+
+func TestSSHConnectionLookup(t *testing.T) {
+	standIn := startSSHStandIn(t)
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"ssh": []interface{}{standIn.block(sshMethodConnection)},
+	})
+	if err := dataSourceRead(context.Background(), d, nil); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if d.Get("ipaddress") != "198.51.100.70" {
+		t.Errorf("Expected the server address of SSH_CONNECTION, got %v", d.Get("ipaddress"))
+	}
+	if want := "ssh://terraform@" + standIn.addr; d.Get("resolver_used") != want {
+		t.Errorf("Expected resolver_used %s, got %v", want, d.Get("resolver_used"))
+	}
+}
+
+func TestSSHConnectionUnexpectedOutput(t *testing.T) {
+	standIn := startSSHStandIn(t)
+	standIn.sshConnection = ""
+
+	tunnel, err := dialSSH(context.Background(), sshConfigFor(t, standIn.block(sshMethodConnection)))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer tunnel.close()
+
+	_, err = tunnel.connectionAddress(context.Background())
+
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Setting != "ssh" {
+		t.Errorf("Expected a response error for ssh, got: %v", err)
+	}
+}
+
+func TestSSHWrongHostKey(t *testing.T) {
+	standIn := startSSHStandIn(t)
+	other := startSSHStandIn(t)
+
+	block := standIn.block(sshMethodTunnel)
+	block["host_key"] = string(ssh.MarshalAuthorizedKey(other.hostKey))
+
+	_, err := dialSSH(context.Background(), sshConfigFor(t, block))
+
+	var sshErr *SSHError
+	if !errors.As(err, &sshErr) || !strings.Contains(err.Error(), "host key mismatch") {
+		t.Errorf("Expected an SSH host key error, got: %v", err)
+	}
+}
+
+func TestSSHKnownHostsFile(t *testing.T) {
+	standIn := startSSHStandIn(t)
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(standIn.addr)}, standIn.hostKey)
+	if err := os.WriteFile(knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+
+	block := standIn.block(sshMethodTunnel)
+	delete(block, "host_key")
+	block["known_hosts_file"] = knownHosts
+
+	tunnel, err := dialSSH(context.Background(), sshConfigFor(t, block))
+	if err != nil {
+		t.Fatalf("Expected the known host to be accepted, got: %v", err)
+	}
+	tunnel.close()
+
+	if err := os.WriteFile(knownHosts, nil, 0o600); err != nil {
+		t.Fatalf("Failed to write known_hosts: %v", err)
+	}
+	if _, err := dialSSH(context.Background(), sshConfigFor(t, block)); err == nil || !strings.Contains(err.Error(), "key is unknown") {
+		t.Errorf("Expected an unknown host to be refused, got: %v", err)
+	}
+}
+
+func TestSSHRefusesGatewayResolvers(t *testing.T) {
+	_, err := lookupExternalIP(context.Background(), "natpmp://", lookupOptions{tunnel: &sshTunnel{}})
+
+	var sourceErr *SourceError
+	if !errors.As(err, &sourceErr) || sourceErr.Setting != "ssh" {
+		t.Errorf("Expected a source error for ssh, got: %v", err)
+	}
+}
+
+func TestSSHMocked(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{"resolver": "https://example.test/ip", "body": "192.0.2.50"})
+
+	// Nothing listens on port 1, so a real connection would fail
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver": "https://example.test/ip",
+		"ssh":      []interface{}{map[string]interface{}{"host": "127.0.0.1", "port": 1, "user": "terraform"}},
+	})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "192.0.2.50" {
+		t.Errorf("Expected the mocked address, got %v", d.Get("ipaddress"))
+	}
+}
+
+func sshConfigFor(t *testing.T, block map[string]interface{}) sshConfig {
+	t.Helper()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"ssh": []interface{}{block}})
+	config, err := expandSSHConfig(d)
+	if err != nil || config == nil {
+		t.Fatalf("Failed to expand the ssh block: %v", err)
+	}
+	return *config
+}
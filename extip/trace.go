@@ -0,0 +1,152 @@
+package extip
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+)
+
+// Phases of a resolver request, used to explain where a timeout happened.
+const (
+	phaseDNS           = "DNS lookup"
+	phaseConnect       = "TCP connect"
+	phaseTLS           = "TLS handshake"
+	phaseSendRequest   = "sending request"
+	phaseAwaitHeaders  = "awaiting response headers"
+	phaseReadingBody   = "reading response body"
+	phaseNotStartedYet = "request setup"
+)
+
+// phaseSettings maps each phase to the argument that bounds it.
+var phaseSettings = map[string]string{
+	phaseDNS:          "dial_timeout",
+	phaseConnect:      "dial_timeout",
+	phaseTLS:          "tls_handshake_timeout",
+	phaseAwaitHeaders: "response_header_timeout",
+	phaseReadingBody:  "total_timeout",
+}
+
+// requestTrace follows a request through its phases via httptrace and
+// collects connection metadata along the way.
+type requestTrace struct {
+	mu         sync.Mutex
+	phase      string
+	remoteAddr string
+	localAddr  string
+	requests   int
+}
+
+func newRequestTrace() *requestTrace {
+	return &requestTrace{phase: phaseNotStartedYet}
+}
+
+func (rt *requestTrace) setPhase(phase string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.phase = phase
+}
+
+// currentPhase returns the phase the request was last seen in.
+func (rt *requestTrace) currentPhase() string {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return rt.phase
+}
+
+// withContext attaches the trace hooks to ctx.
+func (rt *requestTrace) withContext(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { rt.setPhase(phaseDNS) },
+		ConnectStart:      func(string, string) { rt.setPhase(phaseConnect) },
+		TLSHandshakeStart: func() { rt.setPhase(phaseTLS) },
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil {
+				rt.setPhase(phaseSendRequest)
+			}
+		},
+		GotConn:      rt.gotConn,
+		WroteRequest: rt.wroteRequest,
+	})
+}
+
+func (rt *requestTrace) gotConn(info httptrace.GotConnInfo) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.phase = phaseSendRequest
+	if info.Conn != nil {
+		rt.remoteAddr = hostFromAddr(info.Conn.RemoteAddr())
+		rt.localAddr = hostFromAddr(info.Conn.LocalAddr())
+	}
+}
+
+func (rt *requestTrace) wroteRequest(httptrace.WroteRequestInfo) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.phase = phaseAwaitHeaders
+	rt.requests++
+}
+
+// fill copies the collected connection metadata into result.
+func (rt *requestTrace) fill(result *lookupResult) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	result.remoteAddr = rt.remoteAddr
+	result.localAddr = rt.localAddr
+	result.attempts = rt.requests
+}
+
+// hostFromAddr returns the IP of a network address without its port.
+func hostFromAddr(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
+
+// annotateTimeout adds the phase a timeout happened in to err. Errors that are
+// not timeouts are returned unchanged. The setting of the phase is only named
+// when it is configured, otherwise client_timeout is. When ctx, bounded by
+// total_timeout, has expired, total_timeout is reported instead.
+func (rt *requestTrace) annotateTimeout(ctx context.Context, err error, opts lookupOptions) error {
+	if err == nil || !isTimeout(err) {
+		return err
+	}
+
+	phase := rt.currentPhase()
+	setting := phaseSettings[phase]
+	if !opts.timeoutConfigured(setting) {
+		setting = "client_timeout"
+	}
+	if opts.totalTimeout > 0 && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		setting = "total_timeout"
+	}
+	return &TimeoutError{Phase: phase, Setting: setting, Err: err}
+}
+
+// phaseClause introduces phase with the preposition that reads naturally:
+// "while" before an activity ("while reading response body"), "during"
+// before a noun ("during TLS handshake").
+func phaseClause(phase string) string {
+	if first, _, _ := strings.Cut(phase, " "); strings.HasSuffix(first, "ing") {
+		return "while " + phase
+	}
+	return "during " + phase
+}
+
+// isTimeout reports whether err was caused by a deadline or network timeout.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
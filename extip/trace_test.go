@@ -0,0 +1,307 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+func TestGetExternalIPFromResponseHeaderTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{responseHeaderTimeout: 50})
+	if err == nil {
+		t.Fatal("Expected response header timeout")
+	}
+
+	expected := "timed out while awaiting response headers (see response_header_timeout)"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, got: %v", expected, err)
+	}
+}
+
+func TestGetExternalIPFromTLSHandshakeTimeout(t *testing.T) {
+	// A listener that accepts connections but never speaks TLS
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	_, err = getExternalIPFrom(context.Background(), "https://"+listener.Addr().String(), lookupOptions{tlsHandshakeTimeout: 50})
+	if err == nil {
+		t.Fatal("Expected TLS handshake timeout")
+	}
+
+	expected := "timed out during TLS handshake (see tls_handshake_timeout)"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, got: %v", expected, err)
+	}
+}
+
+func TestGetExternalIPFromTotalTimeoutWhileReadingBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+		time.Sleep(200 * time.Millisecond)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{totalTimeout: 50})
+	if err == nil {
+		t.Fatal("Expected total timeout")
+	}
+
+	expected := "timed out while reading response body (see total_timeout)"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("Expected error containing %q, got: %v", expected, err)
+	}
+}
+
+func TestRequestTraceAnnotateTimeout(t *testing.T) {
+	rt := newRequestTrace()
+	opts := lookupOptions{clientTimeout: 1000, dialTimeout: 100}
+
+	plain := errors.New("connection refused")
+	if got := rt.annotateTimeout(context.Background(), plain, opts); got != plain {
+		t.Errorf("Expected non-timeout error to be unchanged, got: %v", got)
+	}
+
+	if got := rt.annotateTimeout(context.Background(), nil, opts); got != nil {
+		t.Errorf("Expected nil error to stay nil, got: %v", got)
+	}
+
+	got := rt.annotateTimeout(context.Background(), context.DeadlineExceeded, opts)
+	if got.Error() != "timed out during request setup (see client_timeout): context deadline exceeded" {
+		t.Errorf("Unexpected annotation before any phase: %v", got)
+	}
+
+	rt.setPhase(phaseDNS)
+	got = rt.annotateTimeout(context.Background(), context.DeadlineExceeded, opts)
+	if got.Error() != "timed out during DNS lookup (see dial_timeout): context deadline exceeded" {
+		t.Errorf("Unexpected annotation during DNS lookup: %v", got)
+	}
+
+	if !errors.Is(got, context.DeadlineExceeded) {
+		t.Error("Expected annotated error to wrap the original")
+	}
+
+	// An unset phase setting did not bound the phase, client_timeout did
+	got = rt.annotateTimeout(context.Background(), context.DeadlineExceeded, lookupOptions{clientTimeout: 1000})
+	if got.Error() != "timed out during DNS lookup (see client_timeout): context deadline exceeded" {
+		t.Errorf("Expected client_timeout to be reported, got: %v", got)
+	}
+
+	rt.setPhase(phaseAwaitHeaders)
+	got = rt.annotateTimeout(context.Background(), context.DeadlineExceeded, opts)
+	if got.Error() != "timed out while awaiting response headers (see client_timeout): context deadline exceeded" {
+		t.Errorf("Unexpected annotation while awaiting headers: %v", got)
+	}
+
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	got = rt.annotateTimeout(expired, context.DeadlineExceeded, lookupOptions{totalTimeout: 100})
+	if got.Error() != "timed out while awaiting response headers (see total_timeout): context deadline exceeded" {
+		t.Errorf("Expected the expired lookup deadline to be reported, got: %v", got)
+	}
+}
+
+func TestGetExternalIPFromTotalTimeoutWhileAwaitingHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	_, err := getExternalIPFrom(context.Background(), server.URL, lookupOptions{clientTimeout: 5000, totalTimeout: 100})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected a timeout, got: %v", err)
+	}
+	if timeoutErr.Phase != phaseAwaitHeaders || timeoutErr.Setting != "total_timeout" {
+		t.Errorf("Expected total_timeout to be reported while awaiting headers, got %+v", timeoutErr)
+	}
+}
+
+func TestDataSourceReadGranularTimeouts(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"dial_timeout":            100,
+		"tls_handshake_timeout":   200,
+		"response_header_timeout": 300,
+		"total_timeout":           400,
+	})
+
+	opts, err := expandLookupOptions(d)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	cfg := opts.transportConfig()
+	if cfg.dialTimeout != 100*time.Millisecond ||
+		cfg.tlsHandshakeTimeout != 200*time.Millisecond ||
+		cfg.responseHeaderTimeout != 300*time.Millisecond {
+		t.Errorf("Unexpected transport configuration: %+v", cfg)
+	}
+
+	if opts.totalTimeout != 400 {
+		t.Errorf("Expected total_timeout 400, got: %d", opts.totalTimeout)
+	}
+}
+
+func TestGetExternalIPFromConnectionMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/start" {
+			http.Redirect(w, r, "/final", http.StatusFound)
+			return
+		}
+		w.WriteHeader(http.StatusNonAuthoritativeInfo)
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	resolver := strings.Replace(server.URL, "http://", "http://user:secret@", 1) + "/start"
+	result, err := getExternalIPFrom(context.Background(), resolver, lookupOptions{
+		maxRedirects:        1,
+		acceptedStatusCodes: []int{203},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if result.httpStatus != http.StatusNonAuthoritativeInfo {
+		t.Errorf("Expected status 203, got: %d", result.httpStatus)
+	}
+
+	if result.remoteAddr != testIP {
+		t.Errorf("Expected remote address %s, got: %s", testIP, result.remoteAddr)
+	}
+
+	if result.attempts != 2 {
+		t.Errorf("Expected 2 requests including the redirect, got: %d", result.attempts)
+	}
+
+	if result.tlsVersion != "" {
+		t.Errorf("Expected no TLS version for plain HTTP, got: %s", result.tlsVersion)
+	}
+
+	if !strings.HasSuffix(result.resolverUsed, "/final") || strings.Contains(result.resolverUsed, "secret") {
+		t.Errorf("Expected final redacted URL, got: %s", result.resolverUsed)
+	}
+
+	if result.latency <= 0 {
+		t.Errorf("Expected positive latency, got: %v", result.latency)
+	}
+}
+
+func TestDataSourceReadSetsLookupMetadata(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(testIP))
+	}))
+	defer server.Close()
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{
+		"resolver": "http://user:secret@" + strings.TrimPrefix(server.URL, "http://"),
+	})
+
+	if err := dataSourceRead(context.Background(), d, nil); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if d.Get("http_status").(int) != http.StatusOK {
+		t.Errorf("Expected http_status 200, got: %v", d.Get("http_status"))
+	}
+
+	if d.Get("attempts").(int) != 1 {
+		t.Errorf("Expected attempts 1, got: %v", d.Get("attempts"))
+	}
+
+	if d.Get("remote_addr").(string) != testIP {
+		t.Errorf("Expected remote_addr %s, got: %v", testIP, d.Get("remote_addr"))
+	}
+
+	if used := d.Get("resolver_used").(string); !strings.Contains(used, "user:xxxxx@") {
+		t.Errorf("Expected redacted resolver_used, got: %s", used)
+	}
+
+	if d.Get("latency_ms").(int) < 0 {
+		t.Errorf("Expected non-negative latency_ms, got: %v", d.Get("latency_ms"))
+	}
+}
+
+func TestDataSourceReadNATDetection(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		behind bool
+	}{
+		{"same address", testIP, false},
+		{"translated address", "203.0.113.1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				_, _ = w.Write([]byte(tt.answer))
+			}))
+			defer server.Close()
+
+			d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": server.URL})
+			if err := dataSourceRead(context.Background(), d, nil); err != nil {
+				t.Fatalf("Expected no error, got: %v", err)
+			}
+
+			if d.Get("local_source_address").(string) != testIP {
+				t.Errorf("Expected local_source_address %s, got: %v", testIP, d.Get("local_source_address"))
+			}
+
+			if d.Get("behind_nat").(bool) != tt.behind {
+				t.Errorf("Expected behind_nat %v, got: %v", tt.behind, d.Get("behind_nat"))
+			}
+		})
+	}
+}
+
+func TestBehindNAT(t *testing.T) {
+	tests := []struct {
+		local, external string
+		behind, ok      bool
+	}{
+		{"192.168.1.10", "203.0.113.1", true, true},
+		{"203.0.113.1", "203.0.113.1", false, true},
+		{"2001:db8::1", "2001:db8:0:0::1", false, true},
+		{"", "203.0.113.1", false, false},
+		{"192.168.1.10", "not an ip", false, false},
+	}
+
+	for _, tt := range tests {
+		behind, ok := behindNAT(tt.local, tt.external)
+		if behind != tt.behind || ok != tt.ok {
+			t.Errorf("behindNAT(%q, %q) = %v, %v, expected %v, %v", tt.local, tt.external, behind, ok, tt.behind, tt.ok)
+		}
+	}
+}
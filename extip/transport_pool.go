@@ -0,0 +1,186 @@
+package extip
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTransportPoolSize bounds how many distinct transports are kept.
+	defaultTransportPoolSize = 16
+
+	// defaultTransportIdleTimeout is how long an unused transport is kept.
+	defaultTransportIdleTimeout = 5 * time.Minute
+)
+
+// transportConfig is the full connection configuration a transport is built
+// from. Every setting that changes how connections are made belongs here, so
+// that lookups with different settings never share connections.
+type transportConfig struct {
+	network               string
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	// proxy is the URL of the proxy requests go through, empty connects directly.
+	proxy string
+	// sourceAddress is the local address connections are bound to, empty lets the system choose.
+	sourceAddress string
+}
+
+// transportPool hands out shared HTTP transports keyed by their connection
+// configuration. It holds at most maxSize transports, drops those unused for
+// longer than idleTimeout and closes everything once the provider stops.
+type transportPool struct {
+	mu          sync.Mutex
+	entries     map[transportConfig]*poolEntry
+	maxSize     int
+	idleTimeout time.Duration
+	closed      bool
+
+	// now is replaced in tests to control idle eviction.
+	now func() time.Time
+}
+
+type poolEntry struct {
+	transport *http.Transport
+	lastUsed  time.Time
+}
+
+func newTransportPool(maxSize int, idleTimeout time.Duration) *transportPool {
+	return &transportPool{
+		entries:     make(map[transportConfig]*poolEntry),
+		maxSize:     maxSize,
+		idleTimeout: idleTimeout,
+		now:         time.Now,
+	}
+}
+
+// fallbackTransports serves lookups made without a configured provider.
+var fallbackTransports = newTransportPool(defaultTransportPoolSize, defaultTransportIdleTimeout)
+
+// get returns the transport for cfg, creating it when needed.
+func (p *transportPool) get(cfg transportConfig) *http.Transport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	p.evictIdle(now)
+
+	if entry, ok := p.entries[cfg]; ok {
+		entry.lastUsed = now
+		return entry.transport
+	}
+
+	transport := newTransport(cfg)
+
+	// A closed pool still works, it just stops caching.
+	if p.closed {
+		return transport
+	}
+
+	if len(p.entries) >= p.maxSize {
+		p.evictOldest()
+	}
+	p.entries[cfg] = &poolEntry{transport: transport, lastUsed: now}
+
+	return transport
+}
+
+// getHTTPClient returns a client for a single lookup. Clients are cheap and
+// carry the per-lookup settings; the pooled transport carries the connections.
+func (p *transportPool) getHTTPClient(opts lookupOptions) *http.Client {
+	var transport *http.Transport
+	if opts.tunnel != nil {
+		// Tunnelled connections end with their SSH connection, so they are not pooled
+		transport = newTransport(opts.transportConfig())
+		transport.DialContext = opts.tunnel.dialContext(opts.network)
+	} else {
+		transport = p.get(opts.transportConfig())
+	}
+
+	return &http.Client{
+		Timeout:       msToDuration(opts.clientTimeout),
+		Transport:     transport,
+		CheckRedirect: redirectPolicy(opts),
+	}
+}
+
+// size returns the number of cached transports.
+func (p *transportPool) size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.entries)
+}
+
+// close releases every cached transport's idle connections and empties the pool.
+func (p *transportPool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for cfg, entry := range p.entries {
+		entry.transport.CloseIdleConnections()
+		delete(p.entries, cfg)
+	}
+	p.closed = true
+}
+
+func (p *transportPool) evictIdle(now time.Time) {
+	if p.idleTimeout <= 0 {
+		return
+	}
+
+	for cfg, entry := range p.entries {
+		if now.Sub(entry.lastUsed) > p.idleTimeout {
+			entry.transport.CloseIdleConnections()
+			delete(p.entries, cfg)
+		}
+	}
+}
+
+func (p *transportPool) evictOldest() {
+	var (
+		oldestCfg   transportConfig
+		oldestEntry *poolEntry
+	)
+
+	for cfg, entry := range p.entries {
+		if oldestEntry == nil || entry.lastUsed.Before(oldestEntry.lastUsed) {
+			oldestCfg, oldestEntry = cfg, entry
+		}
+	}
+
+	if oldestEntry != nil {
+		oldestEntry.transport.CloseIdleConnections()
+		delete(p.entries, oldestCfg)
+	}
+}
+
+func newTransport(cfg transportConfig) *http.Transport {
+	dialer := &net.Dialer{Timeout: cfg.dialTimeout, LocalAddr: localAddr("tcp", cfg.sourceAddress)}
+	dial := dialer.DialContext
+	if cfg.network != "" {
+		dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialer.DialContext(ctx, cfg.network, addr)
+		}
+	}
+
+	transport := &http.Transport{
+		DialContext:           dial,
+		TLSHandshakeTimeout:   cfg.tlsHandshakeTimeout,
+		ResponseHeaderTimeout: cfg.responseHeaderTimeout,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+
+	// The proxy URL is validated by the provider schema
+	if proxyURL, err := url.Parse(cfg.proxy); err == nil && cfg.proxy != "" {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	return transport
+}
@@ -0,0 +1,139 @@
+package extip
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTransportPoolGetHTTPClient(t *testing.T) {
+	pool := newTransportPool(defaultTransportPoolSize, defaultTransportIdleTimeout)
+
+	client1 := pool.getHTTPClient(lookupOptions{clientTimeout: 5000})
+	client2 := pool.getHTTPClient(lookupOptions{clientTimeout: 10000})                        // Same transport
+	client3 := pool.getHTTPClient(lookupOptions{clientTimeout: 5000, tlsHandshakeTimeout: 1}) // Different transport
+
+	if client1.Transport != client2.Transport {
+		t.Error("Expected same transport for same connection settings")
+	}
+
+	if client1.Transport == client3.Transport {
+		t.Error("Expected different transport for different connection settings")
+	}
+
+	if client1.Timeout != 5*time.Second {
+		t.Errorf("Expected timeout %v, got %v", 5*time.Second, client1.Timeout)
+	}
+
+	if client2.Timeout != 10*time.Second {
+		t.Errorf("Expected timeout %v, got %v", 10*time.Second, client2.Timeout)
+	}
+
+	if client1.CheckRedirect == nil {
+		t.Error("Expected redirect policy to be set")
+	}
+
+	if pool.size() != 2 {
+		t.Errorf("Expected 2 cached transports, got %d", pool.size())
+	}
+}
+
+func TestTransportPoolConcurrency(t *testing.T) {
+	pool := newTransportPool(defaultTransportPoolSize, defaultTransportIdleTimeout)
+	cfg := transportConfig{dialTimeout: time.Second}
+
+	var wg sync.WaitGroup
+	transports := make([]*http.Transport, 100)
+
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(index int) {
+			defer wg.Done()
+			transports[index] = pool.get(cfg)
+		}(i)
+	}
+
+	wg.Wait()
+
+	// All transports should be the same instance
+	for i := 1; i < len(transports); i++ {
+		if transports[0] != transports[i] {
+			t.Error("Expected all concurrent requests to return same transport instance")
+		}
+	}
+}
+
+func TestTransportPoolBoundedSize(t *testing.T) {
+	pool := newTransportPool(2, 0)
+	clock := time.Unix(0, 0)
+	pool.now = func() time.Time {
+		clock = clock.Add(time.Second)
+		return clock
+	}
+
+	first := pool.get(transportConfig{dialTimeout: 1})
+	second := pool.get(transportConfig{dialTimeout: 2})
+
+	// Touch the first transport so the second becomes the least recently used
+	if pool.get(transportConfig{dialTimeout: 1}) != first {
+		t.Error("Expected cached transport for first configuration")
+	}
+
+	pool.get(transportConfig{dialTimeout: 3})
+
+	if pool.size() != 2 {
+		t.Errorf("Expected pool to stay at 2 transports, got %d", pool.size())
+	}
+
+	if pool.get(transportConfig{dialTimeout: 1}) != first {
+		t.Error("Expected recently used transport to survive eviction")
+	}
+
+	if pool.get(transportConfig{dialTimeout: 2}) == second {
+		t.Error("Expected least recently used transport to be evicted")
+	}
+}
+
+func TestTransportPoolIdleEviction(t *testing.T) {
+	pool := newTransportPool(defaultTransportPoolSize, time.Minute)
+	clock := time.Unix(0, 0)
+	pool.now = func() time.Time { return clock }
+
+	first := pool.get(transportConfig{})
+
+	clock = clock.Add(30 * time.Second)
+	if pool.get(transportConfig{}) != first {
+		t.Error("Expected transport to be reused within the idle timeout")
+	}
+
+	clock = clock.Add(2 * time.Minute)
+	if pool.get(transportConfig{}) == first {
+		t.Error("Expected idle transport to be evicted")
+	}
+
+	if pool.size() != 1 {
+		t.Errorf("Expected 1 cached transport, got %d", pool.size())
+	}
+}
+
+func TestTransportPoolClose(t *testing.T) {
+	pool := newTransportPool(defaultTransportPoolSize, defaultTransportIdleTimeout)
+	pool.get(transportConfig{})
+	pool.get(transportConfig{dialTimeout: time.Second})
+
+	pool.close()
+
+	if pool.size() != 0 {
+		t.Errorf("Expected empty pool after close, got %d", pool.size())
+	}
+
+	// A closed pool still hands out working transports without caching them
+	if pool.get(transportConfig{}) == nil {
+		t.Error("Expected transport from closed pool")
+	}
+
+	if pool.size() != 0 {
+		t.Errorf("Expected closed pool not to cache, got %d", pool.size())
+	}
+}
@@ -0,0 +1,478 @@
+package extip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	protocolUPnP = "upnp"
+
+	// ssdpMulticastAddress is where SSDP searches are sent unless the
+	// resolver names a device to search directly.
+	ssdpMulticastAddress = "239.255.255.250"
+	ssdpPort             = 1900
+
+	// igdDeviceType is the device searched for. IGD:2 devices answer it too.
+	igdDeviceType = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+
+	// defaultUPnPDiscoveryTimeout is the SSDP discovery wait in milliseconds.
+	defaultUPnPDiscoveryTimeout = 2000
+
+	// maxUPnPResponseBytes caps device descriptions and SOAP responses.
+	maxUPnPResponseBytes = 256 << 10
+)
+
+// wanServiceTypes are the services that implement GetExternalIPAddress,
+// without their version suffix.
+var wanServiceTypes = []string{
+	"urn:schemas-upnp-org:service:WANIPConnection:",
+	"urn:schemas-upnp-org:service:WANPPPConnection:",
+}
+
+// parseUPnPResolver reports whether resolver uses UPnP and, if so, returns
+// the address SSDP searches are sent to: the multicast group for upnp://, or
+// the device named by upnp://HOST[:PORT].
+func parseUPnPResolver(resolver string) (ssdpTarget string, ok bool, err error) {
+	r, ok, err := parseHostResolver(resolver, protocolUPnP)
+	if !ok || err != nil {
+		return "", ok, err
+	}
+
+	host := r.host
+	if host == "" {
+		host = ssdpMulticastAddress
+	} else if net.ParseIP(host) == nil {
+		return "", true, fmt.Errorf("the device in %s must be an IP address", resolver)
+	}
+
+	port := r.port
+	if port == 0 {
+		port = ssdpPort
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(port)), true, nil
+}
+
+// lookupUPnP is the strategy of upnp:// resolvers.
+func lookupUPnP(ctx context.Context, resolver string, opts lookupOptions) (*lookupResult, error) {
+	ssdpTarget, _, _ := parseUPnPResolver(resolver)
+	if opts.tunnel != nil {
+		return &lookupResult{resolverUsed: resolver}, errNotOverSSH(protocolUPnP)
+	}
+	return getExternalIPFromUPnP(ctx, resolver, ssdpTarget, opts)
+}
+
+// getExternalIPFromUPnP asks an Internet Gateway Device for its external
+// address: the device is found with SSDP, or taken from upnp_device_url, and
+// GetExternalIPAddress is invoked on its WAN connection service.
+func getExternalIPFromUPnP(ctx context.Context, resolver, ssdpTarget string, opts lookupOptions) (result *lookupResult, err error) {
+	result = &lookupResult{resolverUsed: resolver, attempts: 1}
+	start := time.Now()
+
+	logDebug(ctx, "Querying resolver", map[string]interface{}{
+		logFieldResolver: result.resolverUsed,
+		logFieldStrategy: protocolUPnP,
+		logFieldAttempt:  1,
+	})
+
+	defer func() {
+		result.latency = time.Since(start)
+		logLookupOutcome(ctx, result, err)
+	}()
+
+	var answer string
+	if opts.mocks != nil {
+		var reason string
+		// Mocked lookups skip discovery, so only a configured device is checked
+		if err = opts.policy.check(resolver, protocolUPnP, upnpConfiguredHost(ssdpTarget, opts.upnpDeviceURL)); err == nil {
+			answer, reason, err = opts.mocks.lookupAnswer(ctx, resolver)
+		}
+		if reason != "" {
+			err = errors.New(reason)
+		}
+	} else {
+		answer, err = queryUPnP(ctx, resolver, ssdpTarget, opts, result)
+	}
+	if opts.recorder != nil {
+		opts.recorder.recordAnswer(ctx, protocolUPnP, resolver, answer, err)
+	}
+	if err != nil {
+		return result, classifyUPnPError(resolver, opts, err)
+	}
+
+	result.ip = answer
+	return result, nil
+}
+
+// upnpConfiguredHost returns the device host known without discovery.
+func upnpConfiguredHost(ssdpTarget, deviceURL string) string {
+	if u, err := url.Parse(deviceURL); err == nil && deviceURL != "" {
+		return u.Hostname()
+	}
+	if host, _, err := net.SplitHostPort(ssdpTarget); err == nil && host != ssdpMulticastAddress {
+		return host
+	}
+	return ""
+}
+
+func queryUPnP(ctx context.Context, resolver, ssdpTarget string, opts lookupOptions, result *lookupResult) (string, error) {
+	host, _, _ := net.SplitHostPort(ssdpTarget)
+	opts.network = "tcp4"
+	if net.ParseIP(host).To4() == nil {
+		opts.network = "tcp6"
+	}
+	opts, err := opts.bindSource()
+	if err != nil {
+		return "", err
+	}
+
+	location := opts.upnpDeviceURL
+	if location == "" {
+		// The search itself contacts the device, so the policy applies before it
+		if err := opts.policy.check(resolver, protocolUPnP, host); err != nil {
+			return "", err
+		}
+		if location, err = discoverIGD(ctx, ssdpTarget, opts); err != nil {
+			return "", err
+		}
+	}
+
+	if opts.clientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, msToDuration(opts.clientTimeout))
+		defer cancel()
+	}
+	ctx = httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			result.remoteAddr = hostFromAddr(info.Conn.RemoteAddr())
+			result.localAddr = hostFromAddr(info.Conn.LocalAddr())
+		},
+	})
+
+	// The gateway is on the local network, so proxies are deliberately skipped
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: (&net.Dialer{LocalAddr: localAddr("tcp", opts.sourceAddress)}).DialContext,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	defer client.CloseIdleConnections()
+
+	controlURL, serviceType, err := fetchWANService(ctx, client, resolver, location, opts.policy)
+	if err != nil {
+		return "", err
+	}
+	return getUPnPExternalIP(ctx, client, resolver, controlURL, serviceType, opts.policy)
+}
+
+// discoverIGD sends an SSDP search to target and returns the description
+// URL of the first Internet Gateway Device that answers. The search is
+// repeated once halfway through upnp_discovery_timeout, as UDP may be lost.
+func discoverIGD(ctx context.Context, target string, opts lookupOptions) (string, error) {
+	network := "udp4"
+	if opts.network == "tcp6" {
+		network = "udp6"
+	}
+
+	dst, err := net.ResolveUDPAddr(network, target)
+	if err != nil {
+		return "", err
+	}
+
+	var local *net.UDPAddr
+	if addr, ok := localAddr("udp", opts.sourceAddress).(*net.UDPAddr); ok {
+		local = addr
+	}
+	conn, err := net.ListenUDP(network, local)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	search := []byte("M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + target + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 1\r\n" +
+		"ST: " + igdDeviceType + "\r\n\r\n")
+
+	for _, deadline := range ssdpDeadlines(ctx, opts.upnpDiscoveryTimeout) {
+		if _, err := conn.WriteTo(search, dst); err != nil {
+			return "", err
+		}
+		location, err := readSSDPAnswer(conn, deadline)
+		if err != nil || location != "" {
+			return location, err
+		}
+	}
+
+	return "", &TimeoutError{
+		Phase:   "UPnP discovery",
+		Setting: "upnp_discovery_timeout",
+		Err:     fmt.Errorf("no Internet Gateway Device answered the search sent to %s", target),
+	}
+}
+
+// ssdpDeadlines returns when to repeat the search and when to give up, within
+// the discovery timeout in ms (0 means the default) and the deadline of ctx.
+func ssdpDeadlines(ctx context.Context, discoveryTimeout int) []time.Time {
+	timeout := msToDuration(discoveryTimeout)
+	if timeout <= 0 {
+		timeout = msToDuration(defaultUPnPDiscoveryTimeout)
+	}
+	end := time.Now().Add(timeout)
+	if deadline, ok := ctx.Deadline(); ok && deadline.Before(end) {
+		end = deadline
+	}
+
+	retry := time.Now().Add(timeout / 2)
+	if retry.After(end) {
+		retry = end
+	}
+	return []time.Time{retry, end}
+}
+
+// readSSDPAnswer reads answers until deadline, returning the description URL
+// of the first gateway, or an empty string when none answered in time.
+func readSSDPAnswer(conn net.PacketConn, deadline time.Time) (string, error) {
+	if err := conn.SetReadDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if isTimeout(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if location := parseSSDPResponse(buf[:n]); location != "" {
+			return location, nil
+		}
+	}
+}
+
+// parseSSDPResponse returns the description URL of an SSDP answer from an
+// Internet Gateway Device, or an empty string for any other datagram.
+func parseSSDPResponse(b []byte) string {
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Header.Get("ST"), "InternetGatewayDevice") {
+		return ""
+	}
+	return resp.Header.Get("Location")
+}
+
+// upnpDescription is the part of a UPnP device description needed to find
+// the WAN connection service.
+type upnpDescription struct {
+	URLBase string     `xml:"URLBase"`
+	Device  upnpDevice `xml:"device"`
+}
+
+type upnpDevice struct {
+	Services []upnpService `xml:"serviceList>service"`
+	Devices  []upnpDevice  `xml:"deviceList>device"`
+}
+
+type upnpService struct {
+	ServiceType string `xml:"serviceType"`
+	ControlURL  string `xml:"controlURL"`
+}
+
+// fetchWANService downloads the device description at location and returns
+// the control URL and type of its WAN connection service.
+func fetchWANService(ctx context.Context, client *http.Client, resolver, location string, policy *resolverPolicy) (string, string, error) {
+	base, err := upnpURL(resolver, location, policy)
+	if err != nil {
+		return "", "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base.String(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	body, status, err := doUPnPRequest(client, req)
+	if err != nil {
+		return "", "", err
+	}
+	if status != http.StatusOK {
+		return "", "", &StatusError{Resolver: base.String(), StatusCode: status}
+	}
+
+	var description upnpDescription
+	if err := xml.Unmarshal(body, &description); err != nil {
+		return "", "", fmt.Errorf("could not parse the device description at %s: %w", base, err)
+	}
+
+	service, ok := findWANService(description.Device)
+	if !ok {
+		return "", "", fmt.Errorf("the device at %s has no WANIPConnection or WANPPPConnection service", base)
+	}
+
+	if description.URLBase != "" {
+		if u, err := url.Parse(description.URLBase); err == nil {
+			base = u
+		}
+	}
+	control, err := base.Parse(service.ControlURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid control URL %q in the device description: %w", service.ControlURL, err)
+	}
+
+	return control.String(), service.ServiceType, nil
+}
+
+// findWANService searches device and its embedded devices, depth first.
+func findWANService(device upnpDevice) (upnpService, bool) {
+	for _, service := range device.Services {
+		for _, serviceType := range wanServiceTypes {
+			if strings.HasPrefix(service.ServiceType, serviceType) && service.ControlURL != "" {
+				return service, true
+			}
+		}
+	}
+	for _, embedded := range device.Devices {
+		if service, ok := findWANService(embedded); ok {
+			return service, true
+		}
+	}
+	return upnpService{}, false
+}
+
+// soapEnvelope is the answer to GetExternalIPAddress, or a fault.
+type soapEnvelope struct {
+	Body struct {
+		Response struct {
+			ExternalIP string `xml:"NewExternalIPAddress"`
+		} `xml:"GetExternalIPAddressResponse"`
+		Fault *struct {
+			String string `xml:"faultstring"`
+			Error  struct {
+				Code        int    `xml:"errorCode"`
+				Description string `xml:"errorDescription"`
+			} `xml:"detail>UPnPError"`
+		} `xml:"Fault"`
+	} `xml:"Body"`
+}
+
+// getUPnPExternalIP invokes GetExternalIPAddress on the service at controlURL.
+func getUPnPExternalIP(ctx context.Context, client *http.Client, resolver, controlURL, serviceType string, policy *resolverPolicy) (string, error) {
+	control, err := upnpURL(resolver, controlURL, policy)
+	if err != nil {
+		return "", err
+	}
+
+	envelope := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"/></s:Body></s:Envelope>`
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, control.String(), strings.NewReader(envelope))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+
+	body, status, err := doUPnPRequest(client, req)
+	if err != nil {
+		return "", err
+	}
+
+	var answer soapEnvelope
+	if err := xml.Unmarshal(body, &answer); err != nil {
+		if status != http.StatusOK {
+			return "", &StatusError{Resolver: control.String(), StatusCode: status}
+		}
+		return "", fmt.Errorf("could not parse the GetExternalIPAddress response: %w", err)
+	}
+
+	if fault := answer.Body.Fault; fault != nil {
+		if fault.Error.Description != "" {
+			return "", fmt.Errorf("gateway refused GetExternalIPAddress: %s (UPnP error %d)", fault.Error.Description, fault.Error.Code)
+		}
+		return "", fmt.Errorf("gateway refused GetExternalIPAddress: %s", fault.String)
+	}
+	if status != http.StatusOK {
+		return "", &StatusError{Resolver: control.String(), StatusCode: status}
+	}
+
+	ip := strings.TrimSpace(answer.Body.Response.ExternalIP)
+	if ip == "" {
+		return "", errors.New("gateway reported no external address, its WAN connection may be down")
+	}
+	return ip, nil
+}
+
+// upnpURL parses a device URL and checks it against the provider policy.
+func upnpURL(resolver, raw string, policy *resolverPolicy) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("the device URL %q is not an http URL", raw)
+	}
+	if err := policy.check(resolver, protocolUPnP, u.Hostname()); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func doUPnPRequest(client *http.Client, req *http.Request) ([]byte, int, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxUPnPResponseBytes))
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, resp.StatusCode, nil
+}
+
+// classifyUPnPError turns a UPnP lookup failure into a typed error.
+func classifyUPnPError(resolver string, opts lookupOptions, err error) error {
+	var (
+		policyErr  *PolicyError
+		sourceErr  *SourceError
+		timeoutErr *TimeoutError
+		statusErr  *StatusError
+		opErr      *net.OpError
+	)
+
+	switch {
+	case errors.As(err, &timeoutErr):
+		timeoutErr.Resolver = resolver
+		return timeoutErr
+	case errors.As(err, &policyErr), errors.As(err, &sourceErr), errors.As(err, &statusErr):
+		return err
+	case isTimeout(err):
+		return &TimeoutError{Resolver: resolver, Phase: "UPnP request", Setting: "client_timeout", Err: err}
+	case errors.As(err, &opErr):
+		return &ConnectionError{Resolver: resolver, Err: err}
+	default:
+		setting := "resolver"
+		if opts.upnpDeviceURL != "" {
+			setting = "upnp_device_url"
+		}
+		return &ResponseError{Resolver: resolver, Setting: setting, Reason: err.Error()}
+	}
+}
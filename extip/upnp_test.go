@@ -0,0 +1,312 @@
+package extip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-sdk/v2/helper/schema"
+)
+
+const testIGDDescription = `<?xml version="1.0"?>
+<root xmlns="urn:schemas-upnp-org:device-1-0">
+  <device>
+    <deviceType>urn:schemas-upnp-org:device:InternetGatewayDevice:1</deviceType>
+    <serviceList>
+      <service>
+        <serviceType>urn:schemas-upnp-org:service:Layer3Forwarding:1</serviceType>
+        <controlURL>/ctl/L3F</controlURL>
+      </service>
+    </serviceList>
+    <deviceList>
+      <device>
+        <deviceType>urn:schemas-upnp-org:device:WANDevice:1</deviceType>
+        <deviceList>
+          <device>
+            <deviceType>urn:schemas-upnp-org:device:WANConnectionDevice:1</deviceType>
+            <serviceList>
+              <service>
+                <serviceType>urn:schemas-upnp-org:service:WANIPConnection:1</serviceType>
+                <controlURL>/ctl/IPConn</controlURL>
+              </service>
+            </serviceList>
+          </device>
+        </deviceList>
+      </device>
+    </deviceList>
+  </device>
+</root>`
+
+const testSOAPFault = `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/">
+  <s:Body>
+    <s:Fault>
+      <faultcode>s:Client</faultcode>
+      <faultstring>UPnPError</faultstring>
+      <detail>
+        <UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+          <errorCode>501</errorCode>
+          <errorDescription>Action Failed</errorDescription>
+        </UPnPError>
+      </detail>
+    </s:Fault>
+  </s:Body>
+</s:Envelope>`
+
+// startIGDStandIn serves a device description and answers GetExternalIPAddress
+// with external, or with a SOAP fault when external is empty. It returns the
+// description URL.
+func startIGDStandIn(t *testing.T, external string) string {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/rootDesc.xml", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, testIGDDescription)
+	})
+	mux.HandleFunc("/ctl/IPConn", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("SOAPAction") != `"urn:schemas-upnp-org:service:WANIPConnection:1#GetExternalIPAddress"` {
+			http.Error(w, "unexpected SOAPAction", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		if external == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = io.WriteString(w, testSOAPFault)
+			return
+		}
+		fmt.Fprintf(w, `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/"><s:Body>
+<u:GetExternalIPAddressResponse xmlns:u="urn:schemas-upnp-org:service:WANIPConnection:1">
+<NewExternalIPAddress>%s</NewExternalIPAddress>
+</u:GetExternalIPAddressResponse></s:Body></s:Envelope>`, external)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL + "/rootDesc.xml"
+}
+
+// startSSDPStandIn answers SSDP searches for an Internet Gateway Device on a
+// local UDP socket, pointing at location. It returns the address to use in a
+// upnp:// resolver.
+func startSSDPStandIn(t *testing.T, location string) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			search := string(buf[:n])
+			if !strings.HasPrefix(search, "M-SEARCH * HTTP/1.1\r\n") || !strings.Contains(search, "ST: "+igdDeviceType) {
+				continue
+			}
+
+			// A printer answering the same search must be ignored
+			_, _ = conn.WriteTo([]byte("HTTP/1.1 200 OK\r\nST: urn:schemas-upnp-org:device:Printer:1\r\nLOCATION: http://127.0.0.1:1/printer.xml\r\n\r\n"), addr)
+			_, _ = conn.WriteTo([]byte("HTTP/1.1 200 OK\r\nCACHE-CONTROL: max-age=120\r\nST: "+igdDeviceType+"\r\nLOCATION: "+location+"\r\n\r\n"), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func TestParseUPnPResolver(t *testing.T) {
+	tests := []struct {
+		resolver string
+		ok       bool
+		target   string
+		wantErr  bool
+	}{
+		{"upnp://", true, "239.255.255.250:1900", false},
+		{"UPNP://192.168.1.1", true, "192.168.1.1:1900", false},
+		{"upnp://192.168.1.1:11900/", true, "192.168.1.1:11900", false},
+		{"upnp://router.lan", true, "", true},
+		{"upnp://192.168.1.1/rootDesc.xml", true, "", true},
+		{"natpmp://", false, "", false},
+		{"https://checkip.amazonaws.com/", false, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.resolver, func(t *testing.T) {
+			target, ok, err := parseUPnPResolver(tt.resolver)
+			if ok != tt.ok {
+				t.Fatalf("Expected ok %v, got %v", tt.ok, ok)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got: %v", tt.wantErr, err)
+			}
+			if target != tt.target {
+				t.Errorf("Expected %q, got %q", tt.target, target)
+			}
+		})
+	}
+}
+
+func TestGetExternalIPFromUPnPDiscovery(t *testing.T) {
+	ssdp := startSSDPStandIn(t, startIGDStandIn(t, "203.0.113.20"))
+
+	result, err := lookupExternalIP(context.Background(), "upnp://"+ssdp, lookupOptions{clientTimeout: 1000, upnpDiscoveryTimeout: 1000})
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+
+	if result.ip != "203.0.113.20" {
+		t.Errorf("Expected 203.0.113.20, got: %s", result.ip)
+	}
+	if result.remoteAddr != testIP {
+		t.Errorf("Expected remote address %s, got: %s", testIP, result.remoteAddr)
+	}
+}
+
+func TestGetExternalIPFromUPnPDeviceURL(t *testing.T) {
+	opts := lookupOptions{clientTimeout: 1000, upnpDeviceURL: startIGDStandIn(t, "203.0.113.21")}
+
+	// Nothing listens for SSDP on port 1, so discovery would fail
+	result, err := lookupExternalIP(context.Background(), "upnp://127.0.0.1:1", opts)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if result.ip != "203.0.113.21" {
+		t.Errorf("Expected 203.0.113.21, got: %s", result.ip)
+	}
+}
+
+func TestGetExternalIPFromUPnPFault(t *testing.T) {
+	opts := lookupOptions{clientTimeout: 1000, upnpDeviceURL: startIGDStandIn(t, "")}
+
+	_, err := lookupExternalIP(context.Background(), "upnp://", opts)
+
+	var responseErr *ResponseError
+	if !errors.As(err, &responseErr) || responseErr.Setting != "upnp_device_url" {
+		t.Fatalf("Expected a response error for upnp_device_url, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "Action Failed (UPnP error 501)") {
+		t.Errorf("Expected the UPnP error to be reported, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromUPnPNoWANService(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, `<root><device><deviceType>urn:schemas-upnp-org:device:MediaServer:1</deviceType></device></root>`)
+	}))
+	defer server.Close()
+
+	_, err := lookupExternalIP(context.Background(), "upnp://", lookupOptions{clientTimeout: 1000, upnpDeviceURL: server.URL})
+	if err == nil || !strings.Contains(err.Error(), "no WANIPConnection or WANPPPConnection service") {
+		t.Errorf("Expected a missing service error, got: %v", err)
+	}
+}
+
+func TestGetExternalIPFromUPnPDiscoveryTimeout(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	_, err = lookupExternalIP(context.Background(), "upnp://"+conn.LocalAddr().String(), lookupOptions{upnpDiscoveryTimeout: 200})
+
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatalf("Expected a timeout, got: %v", err)
+	}
+	if timeoutErr.Phase != "UPnP discovery" || timeoutErr.Setting != "upnp_discovery_timeout" {
+		t.Errorf("Expected a discovery timeout bounded by upnp_discovery_timeout, got %+v", timeoutErr)
+	}
+}
+
+func TestParseSSDPResponse(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{"gateway", "HTTP/1.1 200 OK\r\nST: " + igdDeviceType + "\r\nLocation: http://192.168.1.1:5000/rootDesc.xml\r\n\r\n", "http://192.168.1.1:5000/rootDesc.xml"},
+		{"other device", "HTTP/1.1 200 OK\r\nST: upnp:rootdevice\r\nLocation: http://192.168.1.2/desc.xml\r\n\r\n", ""},
+		{"notify", "NOTIFY * HTTP/1.1\r\nNT: " + igdDeviceType + "\r\n\r\n", ""},
+		{"garbage", "\x00\x01", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseSSDPResponse([]byte(tt.response)); got != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUPnPResolverMocked(t *testing.T) {
+	config := configureMockedProvider(t, map[string]interface{}{"resolver": "upnp://", "body": "192.0.2.31"})
+
+	d := schema.TestResourceDataRaw(t, dataSource().Schema, map[string]interface{}{"resolver": "upnp://"})
+	if err := dataSourceRead(context.Background(), d, config); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Get("ipaddress") != "192.0.2.31" {
+		t.Errorf("Expected the mocked address, got %v", d.Get("ipaddress"))
+	}
+}
+
+func TestUPnPResolverPolicy(t *testing.T) {
+	opts := lookupOptions{
+		clientTimeout: 1000,
+		upnpDeviceURL: startIGDStandIn(t, "203.0.113.22"),
+		policy:        &resolverPolicy{deniedHosts: []string{"127.0.0.1"}},
+	}
+
+	_, err := lookupExternalIP(context.Background(), "upnp://", opts)
+
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Setting != "denied_resolver_hosts" {
+		t.Errorf("Expected denied_resolver_hosts to refuse the device, got: %v", err)
+	}
+}
+
+func TestUPnPResolverPolicyBeforeDiscovery(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	searched := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 2048)
+		if _, _, err := conn.ReadFrom(buf); err == nil {
+			searched <- struct{}{}
+		}
+	}()
+
+	opts := lookupOptions{
+		upnpDiscoveryTimeout: 200,
+		policy:               &resolverPolicy{deniedHosts: []string{"127.0.0.1"}},
+	}
+	_, err = lookupExternalIP(context.Background(), "upnp://"+conn.LocalAddr().String(), opts)
+
+	var policyErr *PolicyError
+	if !errors.As(err, &policyErr) || policyErr.Setting != "denied_resolver_hosts" {
+		t.Errorf("Expected denied_resolver_hosts to refuse the search, got: %v", err)
+	}
+	select {
+	case <-searched:
+		t.Error("Expected no SSDP search to reach a denied host")
+	case <-time.After(100 * time.Millisecond):
+	}
+}